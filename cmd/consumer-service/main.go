@@ -2,59 +2,207 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"time"
 
+	"github.com/vatsal3003/viswals/internal/broker"
+	"github.com/vatsal3003/viswals/internal/broker/memory"
+	"github.com/vatsal3003/viswals/internal/cache"
 	"github.com/vatsal3003/viswals/internal/consts"
 	"github.com/vatsal3003/viswals/internal/csv"
 	"github.com/vatsal3003/viswals/internal/database"
 	"github.com/vatsal3003/viswals/internal/logger"
+	"github.com/vatsal3003/viswals/internal/pipeline"
 	"github.com/vatsal3003/viswals/internal/rabbitmq"
+	"github.com/vatsal3003/viswals/internal/service/userservice"
 	"github.com/vatsal3003/viswals/internal/usersapi"
+	"github.com/vatsal3003/viswals/models"
 	"go.uber.org/zap"
 )
 
+// defaultSQLitePath is the sqlite database file of lite mode
+const defaultSQLitePath = "viswals.db"
+
 func main() {
 	// Initialize logger
 	logger := logger.New()
 	defer logger.Sync()
 
+	// Run migrate subcommand instead of the service
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := runMigrate(logger, os.Args[2:])
+		if err != nil {
+			logger.Sync()
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Lite mode runs on sqlite and in-memory broker, without postgres, redis and rabbitmq
+	lite := os.Getenv(consts.LiteMode) == "true"
+
 	// Initialize database
-	db, err := database.New(logger)
+	var db *database.Database
+	var err error
+	if lite {
+		db, err = database.NewLite(logger, envOrDefault(consts.SQLitePath, defaultSQLitePath))
+		if err != nil {
+			return
+		}
+	} else {
+		db, err = database.New(logger)
+		if err != nil {
+			return
+		}
+
+		// Migrate database
+		if os.Getenv(consts.MigrateDatabase) == "true" {
+			err := db.Migrate(logger, 0)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	// Parse the delays between retries of failed messages
+	retryBackoff, err := pipeline.ParseBackoff(os.Getenv(consts.RabbitMQRetries))
 	if err != nil {
+		logger.Error("failed to parse rabbitmq retry backoff:" + err.Error())
 		return
 	}
 
-	// Migrate database
-	if os.Getenv(consts.MigrateDatabase) == "true" {
-		err := db.Migrate(logger)
-		if err != nil {
+	// Parse the size of worker pool which processes the messages
+	var workers int
+	if value := os.Getenv(consts.ConsumerWorkers); value != "" {
+		workers, err = strconv.Atoi(value)
+		if err != nil || workers <= 0 {
+			logger.Error("invalid consumer workers " + value + ", expected a positive integer")
 			return
 		}
 	}
 
-	// Initialize rabbitmq
-	rmq, err := rabbitmq.New(logger, &rabbitmq.Options{
-		Arguments:  nil,
-		Durable:    true,
-		AutoDelete: false,
-		Exclusive:  false,
-		NoWait:     false,
-	})
+	// Parse the size and flush interval of batches stored into database
+	var batchSize int
+	if value := os.Getenv(consts.ConsumerBatchSize); value != "" {
+		batchSize, err = strconv.Atoi(value)
+		if err != nil || batchSize <= 0 {
+			logger.Error("invalid consumer batch size " + value + ", expected a positive integer")
+			return
+		}
+	}
+
+	var flushInterval time.Duration
+	if value := os.Getenv(consts.ConsumerFlush); value != "" {
+		flushInterval, err = time.ParseDuration(value)
+		if err != nil || flushInterval <= 0 {
+			logger.Error("invalid consumer flush interval " + value + ", expected a positive duration")
+			return
+		}
+	}
+
+	// Parse the ttl of cached users and the size of in-process cache used when redis is not available
+	cacheTTL := cache.DefaultTTL
+	if value := os.Getenv(consts.CacheTTL); value != "" {
+		cacheTTL, err = time.ParseDuration(value)
+		if err != nil || cacheTTL < 0 {
+			logger.Error("invalid cache ttl " + value + ", expected a duration, 0 disables the expiry")
+			return
+		}
+	}
+
+	var cacheSize int
+	if value := os.Getenv(consts.CacheSize); value != "" {
+		cacheSize, err = strconv.Atoi(value)
+		if err != nil || cacheSize <= 0 {
+			logger.Error("invalid cache size " + value + ", expected a positive integer")
+			return
+		}
+	}
+
+	userCache := db.NewCache(logger, cacheTTL, cacheSize)
+
+	// Parse the policy for consumed users which already exist
+	conflictPolicy, err := userservice.ParseConflictPolicy(os.Getenv(consts.ConsumerConflict))
 	if err != nil {
+		logger.Error("failed to parse consumer conflict policy:" + err.Error())
 		return
 	}
 
-	// Start consuming messages from rabbitmq
-	go csv.DigestCSV(logger, rmq, db)
+	// Initialize broker
+	defaultBroker := consts.BrokerRabbitMQ
+	if lite {
+		defaultBroker = consts.BrokerMemory
+	}
+
+	var messageBroker consumerBroker
+	switch brokerName := envOrDefault(consts.Broker, defaultBroker); brokerName {
+	case consts.BrokerRabbitMQ:
+		rmq, err := rabbitmq.New(logger, &rabbitmq.Options{
+			Arguments:          nil,
+			Durable:            true,
+			AutoDelete:         false,
+			Exclusive:          false,
+			NoWait:             false,
+			DeadLetterExchange: os.Getenv(consts.RabbitMQDLXName),
+			DeadLetterQueue:    os.Getenv(consts.RabbitMQDLQName),
+		})
+		if err != nil {
+			return
+		}
+		messageBroker = rabbitMQBroker{rmq}
+	case consts.BrokerMemory:
+		memoryBroker := memory.New()
+		messageBroker = memoryBroker
+
+		// Ingest the csv sources in process, as nothing else can publish to in-memory broker
+		go ingestInProcess(logger, memoryBroker)
+	default:
+		logger.Error("invalid broker " + brokerName + ", expected rabbitmq or memory")
+		return
+	}
+
+	// Stored users are broadcast through the hub to the clients of users stream
+	hub := userservice.NewHub(userservice.DefaultHubSize)
+
+	consumer := pipeline.NewConsumer(messageBroker, pipeline.NewDatabaseStore(db, userCache, conflictPolicy, hub), pipeline.ConsumerOptions{
+		Workers:       workers,
+		BatchSize:     batchSize,
+		FlushInterval: flushInterval,
+		RetryBackoff:  retryBackoff,
+	})
+
+	// Start consuming messages from broker
+	go csv.DigestCSV(logger, consumer)
 
 	// Initialize users api
-	api := usersapi.New(db, logger)
+	api := usersapi.New(db, userCache, hub, logger)
 
 	// Define routes
 	api.InitRoutes()
 
+	// Expose readiness of message pipeline
+	http.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		status, code := consts.StatusSuccess, http.StatusOK
+		if !messageBroker.Ready() {
+			status, code = consts.StatusError, http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+
+		err := json.NewEncoder(w).Encode(models.Response{
+			Status: status,
+			Data:   readiness(messageBroker, consumer, userCache),
+		})
+		if err != nil {
+			logger.Error("failed to encode readiness to JSON: " + err.Error())
+		}
+	})
+
 	// Define server
 	server := &http.Server{
 		Addr:    os.Getenv(consts.ConsumerPort),
@@ -65,16 +213,16 @@ func main() {
 	interruptChan := make(chan os.Signal, 1)
 	signal.Notify(interruptChan, os.Interrupt)
 
-	go func(rmq *rabbitmq.RabbitMQ, logger *zap.Logger) {
+	go func(messageBroker consumerBroker, logger *zap.Logger) {
 		<-interruptChan
-		rmq.CloseResources()
+		messageBroker.Close()
 		db.Close()
 		err = server.Shutdown(context.Background())
 		if err != nil {
 			logger.Error("failed to shutdown http server:" + err.Error())
 		}
 		logger.Info("resources cleaned")
-	}(rmq, logger)
+	}(messageBroker, logger)
 
 	// Start http server
 	err = server.ListenAndServe()
@@ -82,3 +230,65 @@ func main() {
 		logger.Error("failed to start server:" + err.Error())
 	}
 }
+
+// consumerBroker is the broker consumer runs on
+type consumerBroker interface {
+	broker.Subscriber
+	Ready() bool
+	QueueDepth() (int, error)
+	Close()
+}
+
+// rabbitMQBroker closes the rabbitmq resources on Close
+type rabbitMQBroker struct {
+	*rabbitmq.RabbitMQ
+}
+
+func (b rabbitMQBroker) Close() {
+	b.CloseResources()
+}
+
+// readiness will return the readiness of broker with the consumer pipeline and cache stats
+func readiness(messageBroker consumerBroker, consumer *pipeline.Consumer, userCache cache.Cache) map[string]any {
+	data := map[string]any{
+		"broker_ready": messageBroker.Ready(),
+		"consumer":     consumer.Stats(),
+		"cache":        userCache.Stats(),
+	}
+
+	if depth, err := messageBroker.QueueDepth(); err == nil {
+		data["queue_depth"] = depth
+	}
+	if rmq, ok := messageBroker.(rabbitMQBroker); ok {
+		data["rabbitmq_reconnects"] = rmq.Reconnects()
+	}
+
+	return data
+}
+
+// ingestInProcess will publish the csv files of CSV_SOURCE env to the in-memory broker
+func ingestInProcess(logger *zap.Logger, memoryBroker *memory.Broker) {
+	files, err := csv.ResolveFiles(csv.SplitSources(os.Getenv(consts.CSVSource)))
+	if err != nil {
+		logger.Error("failed to resolve csv sources:" + err.Error())
+		return
+	}
+
+	producer := pipeline.NewProducer(memoryBroker, pipeline.ProducerOptions{})
+	for _, report := range csv.IngestFiles(context.Background(), logger, producer, files, csv.Options{}) {
+		if report.Err != nil {
+			logger.Error("failed to ingest csv file", zap.String("file", report.Path), zap.Error(report.Err))
+			continue
+		}
+		logger.Info("csv file ingested", zap.String("file", report.Path), zap.Int("published", report.Published), zap.Int("rejected", report.Rejected))
+	}
+}
+
+// envOrDefault will return the env value, or the default value if env is not set
+func envOrDefault(key, defaultValue string) string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue
+	}
+	return value
+}
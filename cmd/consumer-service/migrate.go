@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/vatsal3003/viswals/internal/database"
+	"go.uber.org/zap"
+)
+
+// migrateUsage describes the arguments of migrate subcommand
+const migrateUsage = "usage: consumer-service migrate up [N] | down N | version | force N (-1 when no migration is applied)"
+
+// runMigrate will run the migrate subcommand on postgres: up applies all or N pending migrations,
+// down reverts the last N migrations, version reports the applied version and force sets the version after a failed migration,
+// force -1 marks that no migration is applied, for a first migration which failed halfway
+func runMigrate(logger *zap.Logger, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		logger.Error(migrateUsage)
+		return errors.New(migrateUsage)
+	}
+
+	var n int
+	if len(args) == 2 {
+		var err error
+		n, err = strconv.Atoi(args[1])
+		if err != nil || (n < 0 && !(args[0] == "force" && n == -1)) {
+			logger.Error("invalid migration count " + args[1] + ", " + migrateUsage)
+			return errors.New(migrateUsage)
+		}
+	}
+
+	command := args[0]
+	switch {
+	case command == "up":
+	case command == "version" && len(args) == 1:
+	case (command == "down" || command == "force") && len(args) == 2:
+	default:
+		logger.Error(migrateUsage)
+		return errors.New(migrateUsage)
+	}
+
+	db, err := database.NewPostgres(logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch command {
+	case "up":
+		return db.Migrate(logger, n)
+	case "down":
+		return db.MigrateDown(logger, n)
+	case "force":
+		return db.ForceMigration(logger, n)
+	default:
+		version, dirty, err := db.MigrationVersion(logger)
+		if err != nil {
+			return err
+		}
+		logger.Info("database migration version", zap.Uint("version", version), zap.Bool("dirty", dirty))
+		return nil
+	}
+}
@@ -1,47 +1,180 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"os"
 	"os/signal"
+	"time"
 
 	_ "github.com/lib/pq"
+	"github.com/vatsal3003/viswals/internal/consts"
 	"github.com/vatsal3003/viswals/internal/csv"
 	"github.com/vatsal3003/viswals/internal/logger"
+	"github.com/vatsal3003/viswals/internal/pipeline"
 	"github.com/vatsal3003/viswals/internal/rabbitmq"
 	"go.uber.org/zap"
 )
 
+const (
+	// defaultCSVSource is used when no source is given through flag, arguments or env
+	defaultCSVSource = "/users.csv"
+	// defaultStateFile keeps the checkpoints of ingested files
+	defaultStateFile = "producer-state.json"
+)
+
 func main() {
+	// Parse flags, csv sources can also be passed as arguments
+	source := flag.String("source", os.Getenv(consts.CSVSource), "comma separated csv files, glob patterns or directories to ingest")
+	watchDir := flag.String("watch-dir", os.Getenv(consts.CSVWatchDir), "inbox directory to watch for new csv files, enables watch mode")
+	columnAliases := flag.String("column-aliases", os.Getenv(consts.CSVColumnAliases), "comma separated alias=column pairs to map csv header names, e.g. email=email_address")
+	rejectsDir := flag.String("rejects-dir", "", "directory of rejects csv files, defaults to the directory of ingested file")
+	stateFile := flag.String("state-file", envOrDefault(consts.CSVStateFile, defaultStateFile), "state file to keep ingestion checkpoints, empty disables checkpoints")
+	fromScratch := flag.Bool("from-scratch", false, "ignore the checkpoints and ingest files from start")
+	codecName := flag.String("codec", os.Getenv(consts.RabbitMQCodec), "codec of published messages: gob, json or protobuf, defaults to gob")
+	pollInterval := flag.Duration("poll-interval", 5*time.Second, "interval between two scans of the inbox directory in watch mode")
+	flag.Parse()
+
 	// Initialize logger
 	logger := logger.New()
 	defer logger.Sync()
 
 	logger.Info("producer service")
 
+	aliases, err := csv.ParseAliases(*columnAliases)
+	if err != nil {
+		logger.Error("failed to parse csv column aliases:" + err.Error())
+		logger.Sync()
+		os.Exit(1)
+	}
+	ingestOpts := csv.Options{
+		Aliases:     aliases,
+		RejectsDir:  *rejectsDir,
+		FromScratch: *fromScratch,
+	}
+
+	if *stateFile != "" {
+		ingestOpts.Checkpoints, err = csv.LoadCheckpoints(*stateFile)
+		if err != nil {
+			logger.Error("failed to load csv checkpoints:" + err.Error())
+			logger.Sync()
+			os.Exit(1)
+		}
+	}
+
+	// Resolve the csv files to ingest when not running in watch mode
+	var files []string
+	if *watchDir == "" {
+		sources := append(csv.SplitSources(*source), flag.Args()...)
+		if len(sources) == 0 {
+			sources = []string{defaultCSVSource}
+		}
+
+		files, err = csv.ResolveFiles(sources)
+		if err != nil {
+			logger.Error("failed to resolve csv sources:" + err.Error())
+			logger.Sync()
+			os.Exit(1)
+		}
+	}
+
+	codec, err := pipeline.CodecByName(*codecName)
+	if err != nil {
+		logger.Error("failed to parse rabbitmq codec:" + err.Error())
+		logger.Sync()
+		os.Exit(1)
+	}
+
 	// Initialize rabbitmq
 	rmq, err := rabbitmq.New(logger, &rabbitmq.Options{
-		Arguments:  nil,
-		Durable:    true,
-		AutoDelete: false,
-		Exclusive:  false,
-		NoWait:     false,
+		Arguments:          nil,
+		Durable:            true,
+		AutoDelete:         false,
+		Exclusive:          false,
+		NoWait:             false,
+		DeadLetterExchange: os.Getenv(consts.RabbitMQDLXName),
+		DeadLetterQueue:    os.Getenv(consts.RabbitMQDLQName),
 	})
 	if err != nil {
 		return
 	}
 
-	// Gracefully shutdown application
+	producer := pipeline.NewProducer(rmq, pipeline.ProducerOptions{Codec: codec})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Gracefully shutdown application, ingestion stops after the published messages are confirmed
+	// and rabbitmq is closed once it returns, so the checkpoints are kept for the next start
 	interruptChan := make(chan os.Signal, 1)
 	signal.Notify(interruptChan, os.Interrupt)
 
-	go func(rmq *rabbitmq.RabbitMQ, logger *zap.Logger) {
+	go func() {
 		<-interruptChan
-		// rmq.CloseResources()
-		logger.Info("resources cleaned")
-	}(rmq, logger)
+		logger.Info("stopping csv ingestion")
+		cancel()
+	}()
+
+	// Keep ingesting the files landing in inbox directory until interrupted
+	if *watchDir != "" {
+		err = csv.Watch(ctx, logger, producer, csv.WatchOptions{
+			Dir:          *watchDir,
+			PollInterval: *pollInterval,
+			Ingest:       ingestOpts,
+		})
+		closeResources(logger, rmq)
+		if err != nil {
+			logger.Sync()
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Start reading csv files and send message to rabbitmq
+	reports := csv.IngestFiles(ctx, logger, producer, files, ingestOpts)
+	closeResources(logger, rmq)
+
+	// Report the result of every file
+	failed := 0
+	for _, report := range reports {
+		if errors.Is(report.Err, context.Canceled) {
+			logger.Info("csv file ingestion is stopped", zap.String("file", report.Path), zap.Int("published", report.Published), zap.Int("confirmed", report.Confirmed))
+			continue
+		}
+		if report.Err != nil {
+			failed++
+			logger.Error("failed to ingest csv file", zap.String("file", report.Path), zap.Int("published", report.Published), zap.Int("confirmed", report.Confirmed), zap.Int("failed", report.Failed), zap.Int("rejected", report.Rejected), zap.Error(report.Err))
+			continue
+		}
+		logger.Info("csv file ingested", zap.String("file", report.Path), zap.String("batch_id", report.BatchID), zap.Int("published", report.Published), zap.Int("confirmed", report.Confirmed), zap.Int("failed", report.Failed), zap.Int("rejected", report.Rejected))
+	}
+
+	if failed > 0 {
+		logger.Error("csv ingestion completed with failures", zap.Int("files", len(reports)), zap.Int("failed", failed))
+		logger.Sync()
+		os.Exit(1)
+	}
+
+	if ctx.Err() != nil {
+		logger.Info("csv ingestion is stopped, files are resumed from checkpoints on next start", zap.Int("files", len(files)), zap.Int("reports", len(reports)))
+		return
+	}
 
-	// Start reading csv and send message to rabbitmq
-	csv.IngestCSV(logger, rmq)
+	logger.Info("csv ingested successfully", zap.Int("files", len(reports)))
+}
+
+// closeResources will close the rabbitmq connection once nothing is published anymore
+func closeResources(logger *zap.Logger, rmq *rabbitmq.RabbitMQ) {
+	rmq.CloseResources()
+	logger.Info("resources cleaned")
+}
 
-	logger.Info("csv ingested successfully")
+// envOrDefault will return the env value, or the default value if env is not set
+func envOrDefault(key, defaultValue string) string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue
+	}
+	return value
 }
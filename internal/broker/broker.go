@@ -0,0 +1,51 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrClosed is returned once the broker is closed
+var ErrClosed = errors.New("broker is closed")
+
+// Message is published to the queue and delivered back from it
+type Message struct {
+	Headers     map[string]any
+	ContentType string
+	Timestamp   time.Time
+	Body        []byte
+}
+
+// Confirmation is the confirmation of a published message
+type Confirmation interface {
+	// Wait will wait until broker confirms the message and report whether broker accepted it
+	Wait(ctx context.Context) (bool, error)
+}
+
+// Publisher publishes messages to the queue
+type Publisher interface {
+	// Publish will publish the message and return its confirmation, it waits while broker is disconnected
+	Publish(ctx context.Context, message Message) (Confirmation, error)
+}
+
+// Delivery is a message delivered from the queue, it is settled exactly once with Ack, Nack, Retry or DeadLetter
+type Delivery interface {
+	Message() Message
+	// Ack will remove the message from the queue
+	Ack() error
+	// Nack will deliver the message again when it is requeued, or move it to dead letter queue otherwise
+	Nack(requeue bool) error
+	// Retry will deliver the message again with the headers after the delay, message is left unsettled when it fails
+	Retry(delay time.Duration, headers map[string]any) error
+	// DeadLetter will move the message with the headers to dead letter queue, message is left unsettled when it fails
+	DeadLetter(headers map[string]any) error
+}
+
+// Subscriber delivers the messages of the queue
+type Subscriber interface {
+	// Subscribe will deliver the messages of the queue, at most prefetch messages are delivered without being settled
+	// deliveries are closed when the connection is lost and the unsettled messages are delivered again to the next subscription,
+	// it returns ErrClosed once the broker is closed
+	Subscribe(ctx context.Context, prefetch int) (<-chan Delivery, error)
+}
@@ -0,0 +1,268 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vatsal3003/viswals/internal/broker"
+)
+
+// ErrSettled is returned when a delivery is settled more than once
+var ErrSettled = errors.New("delivery is already settled")
+
+// Broker is an in-process queue with the ack and nack semantics of a RabbitMQ queue
+// it is used to run the whole pipeline in one process, e.g. in tests and local runs
+type Broker struct {
+	mu          sync.Mutex
+	ready       []broker.Message
+	unsettled   int
+	scheduled   int
+	deadLetters []broker.Message
+	// changed is closed and replaced whenever the queue is changed
+	changed chan struct{}
+	closed  bool
+
+	nack func(message broker.Message) bool
+}
+
+// New will create an empty broker
+func New() *Broker {
+	return &Broker{changed: make(chan struct{})}
+}
+
+// NackWith will nack the published messages for which nack returns true, instead of queueing them
+func (b *Broker) NackWith(nack func(message broker.Message) bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nack = nack
+}
+
+// Publish will queue the message, it is confirmed right away
+func (b *Broker) Publish(ctx context.Context, message broker.Message) (broker.Confirmation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, broker.ErrClosed
+	}
+	if b.nack != nil && b.nack(message) {
+		return confirmation(false), nil
+	}
+
+	message.Headers = maps.Clone(message.Headers)
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+	b.ready = append(b.ready, message)
+	b.notify()
+
+	return confirmation(true), nil
+}
+
+// Subscribe will deliver the queued messages until ctx is done or the broker is closed
+func (b *Broker) Subscribe(ctx context.Context, prefetch int) (<-chan broker.Delivery, error) {
+	b.mu.Lock()
+	closed := b.closed
+	b.mu.Unlock()
+
+	if closed {
+		return nil, broker.ErrClosed
+	}
+
+	deliveries := make(chan broker.Delivery)
+	go func() {
+		defer close(deliveries)
+
+		for {
+			b.mu.Lock()
+			if b.closed {
+				b.mu.Unlock()
+				return
+			}
+
+			if len(b.ready) == 0 || (prefetch > 0 && b.unsettled >= prefetch) {
+				changed := b.changed
+				b.mu.Unlock()
+
+				select {
+				case <-changed:
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+
+			message := b.ready[0]
+			b.ready = b.ready[1:]
+			b.unsettled++
+			b.mu.Unlock()
+
+			select {
+			case deliveries <- &delivery{broker: b, message: message}:
+			case <-ctx.Done():
+				b.requeue(message)
+				return
+			}
+		}
+	}()
+
+	return deliveries, nil
+}
+
+// DeadLetters will return the messages moved to dead letter queue
+func (b *Broker) DeadLetters() []broker.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]broker.Message(nil), b.deadLetters...)
+}
+
+// QueueDepth will return the number of queued messages waiting for delivery
+func (b *Broker) QueueDepth() (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.ready), nil
+}
+
+// Ready will report whether the broker is not closed
+func (b *Broker) Ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return !b.closed
+}
+
+// Idle will report whether no message is queued, unsettled or scheduled for retry
+func (b *Broker) Idle() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.ready) == 0 && b.unsettled == 0 && b.scheduled == 0
+}
+
+// Close will close the subscriptions, the queued messages are dropped
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	b.notify()
+}
+
+// notify will wake up the subscriptions, it must be called with the lock held
+func (b *Broker) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// requeue will put the unsettled message back at the head of the queue
+func (b *Broker) requeue(message broker.Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.unsettled--
+	b.ready = append([]broker.Message{message}, b.ready...)
+	b.notify()
+}
+
+// settle will release the unsettled message, dead lettered message is kept in dead letter queue
+func (b *Broker) settle(deadLetter *broker.Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.unsettled--
+	if deadLetter != nil {
+		b.deadLetters = append(b.deadLetters, *deadLetter)
+	}
+	b.notify()
+}
+
+// schedule will queue the message again after the delay
+func (b *Broker) schedule(message broker.Message, delay time.Duration) {
+	b.mu.Lock()
+	b.unsettled--
+	b.scheduled++
+	b.notify()
+	b.mu.Unlock()
+
+	time.AfterFunc(delay, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		b.scheduled--
+		if !b.closed {
+			b.ready = append(b.ready, message)
+		}
+		b.notify()
+	})
+}
+
+type confirmation bool
+
+func (c confirmation) Wait(ctx context.Context) (bool, error) {
+	return bool(c), nil
+}
+
+type delivery struct {
+	broker  *Broker
+	message broker.Message
+	settled atomic.Bool
+}
+
+func (d *delivery) Message() broker.Message {
+	return d.message
+}
+
+func (d *delivery) Ack() error {
+	if !d.settled.CompareAndSwap(false, true) {
+		return ErrSettled
+	}
+	d.broker.settle(nil)
+	return nil
+}
+
+func (d *delivery) Nack(requeue bool) error {
+	if !d.settled.CompareAndSwap(false, true) {
+		return ErrSettled
+	}
+	if requeue {
+		d.broker.requeue(d.message)
+		return nil
+	}
+	d.broker.settle(&d.message)
+	return nil
+}
+
+func (d *delivery) Retry(delay time.Duration, headers map[string]any) error {
+	if !d.settled.CompareAndSwap(false, true) {
+		return ErrSettled
+	}
+	d.broker.schedule(withHeaders(d.message, headers), delay)
+	return nil
+}
+
+func (d *delivery) DeadLetter(headers map[string]any) error {
+	if !d.settled.CompareAndSwap(false, true) {
+		return ErrSettled
+	}
+	message := withHeaders(d.message, headers)
+	d.broker.settle(&message)
+	return nil
+}
+
+// withHeaders will return the message with the headers added to its own headers
+func withHeaders(message broker.Message, headers map[string]any) broker.Message {
+	merged := maps.Clone(message.Headers)
+	if merged == nil {
+		merged = make(map[string]any, len(headers))
+	}
+	maps.Copy(merged, headers)
+	message.Headers = merged
+	return message
+}
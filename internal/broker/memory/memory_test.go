@@ -0,0 +1,179 @@
+package memory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vatsal3003/viswals/internal/broker"
+	"github.com/vatsal3003/viswals/internal/broker/memory"
+)
+
+// publish will queue the messages with the bodies
+func publish(t *testing.T, b *memory.Broker, bodies ...string) {
+	for _, body := range bodies {
+		confirmation, err := b.Publish(context.Background(), broker.Message{Body: []byte(body)})
+		if assert.NoError(t, err) {
+			acked, err := confirmation.Wait(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, acked)
+		}
+	}
+}
+
+// subscribe will subscribe to the broker until the test ends
+func subscribe(t *testing.T, b *memory.Broker, prefetch int) <-chan broker.Delivery {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	deliveries, err := b.Subscribe(ctx, prefetch)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return deliveries
+}
+
+// receive will wait for the next delivery and check its body
+func receive(t *testing.T, deliveries <-chan broker.Delivery, wantBody string) broker.Delivery {
+	select {
+	case delivery := <-deliveries:
+		assert.Equal(t, wantBody, string(delivery.Message().Body))
+		return delivery
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message is delivered, want " + wantBody)
+		return nil
+	}
+}
+
+// assertNoDelivery will check that nothing is delivered for a while
+func assertNoDelivery(t *testing.T, deliveries <-chan broker.Delivery) {
+	select {
+	case delivery := <-deliveries:
+		t.Error("unexpected delivery " + string(delivery.Message().Body))
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBroker(t *testing.T) {
+	t.Run("Prefetch limits unsettled deliveries", func(t *testing.T) {
+		b := memory.New()
+		defer b.Close()
+
+		publish(t, b, "a", "b", "c")
+		deliveries := subscribe(t, b, 2)
+
+		a := receive(t, deliveries, "a")
+		receive(t, deliveries, "b")
+		assertNoDelivery(t, deliveries)
+
+		assert.NoError(t, a.Ack())
+		receive(t, deliveries, "c")
+	})
+
+	t.Run("Requeued message is delivered first", func(t *testing.T) {
+		b := memory.New()
+		defer b.Close()
+
+		publish(t, b, "a", "b")
+		deliveries := subscribe(t, b, 1)
+
+		assert.NoError(t, receive(t, deliveries, "a").Nack(true))
+		assert.NoError(t, receive(t, deliveries, "a").Ack())
+		assert.NoError(t, receive(t, deliveries, "b").Ack())
+		assert.True(t, b.Idle())
+	})
+
+	t.Run("Rejected message is dead lettered", func(t *testing.T) {
+		b := memory.New()
+		defer b.Close()
+
+		publish(t, b, "a", "b")
+		deliveries := subscribe(t, b, 0)
+
+		assert.NoError(t, receive(t, deliveries, "a").Nack(false))
+		assert.NoError(t, receive(t, deliveries, "b").DeadLetter(map[string]any{"x-reason": "invalid"}))
+
+		deadLetters := b.DeadLetters()
+		if assert.Len(t, deadLetters, 2) {
+			assert.Equal(t, "a", string(deadLetters[0].Body))
+			assert.Equal(t, "invalid", deadLetters[1].Headers["x-reason"])
+		}
+		assert.True(t, b.Idle())
+	})
+
+	t.Run("Retried message is delivered again after delay", func(t *testing.T) {
+		b := memory.New()
+		defer b.Close()
+
+		publish(t, b, "a")
+		deliveries := subscribe(t, b, 0)
+
+		assert.NoError(t, receive(t, deliveries, "a").Retry(20*time.Millisecond, map[string]any{"x-retry-count": 1}))
+		assert.False(t, b.Idle(), "scheduled message should keep the broker busy")
+
+		retried := receive(t, deliveries, "a")
+		assert.Equal(t, 1, retried.Message().Headers["x-retry-count"])
+		assert.NoError(t, retried.Ack())
+	})
+
+	t.Run("Message in hand is requeued when subscription is cancelled", func(t *testing.T) {
+		b := memory.New()
+		defer b.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		_, err := b.Subscribe(ctx, 0)
+		assert.NoError(t, err)
+
+		// Nobody receives the delivery, so subscription holds the message until it is cancelled
+		publish(t, b, "a")
+		assert.Eventually(t, func() bool {
+			depth, _ := b.QueueDepth()
+			return depth == 0
+		}, 5*time.Second, time.Millisecond)
+
+		cancel()
+		assert.Eventually(t, func() bool {
+			depth, _ := b.QueueDepth()
+			return depth == 1
+		}, 5*time.Second, time.Millisecond)
+
+		assert.NoError(t, receive(t, subscribe(t, b, 0), "a").Ack())
+		assert.True(t, b.Idle())
+	})
+
+	t.Run("Delivery is settled once", func(t *testing.T) {
+		b := memory.New()
+		defer b.Close()
+
+		publish(t, b, "a")
+		delivery := receive(t, subscribe(t, b, 0), "a")
+
+		assert.NoError(t, delivery.Ack())
+		assert.ErrorIs(t, delivery.Ack(), memory.ErrSettled)
+		assert.ErrorIs(t, delivery.Nack(true), memory.ErrSettled)
+		assert.ErrorIs(t, delivery.Retry(time.Millisecond, nil), memory.ErrSettled)
+		assert.ErrorIs(t, delivery.DeadLetter(nil), memory.ErrSettled)
+
+		depth, err := b.QueueDepth()
+		assert.NoError(t, err)
+		assert.Equal(t, 0, depth)
+		assert.Empty(t, b.DeadLetters())
+		assert.True(t, b.Idle())
+	})
+
+	t.Run("Closed broker", func(t *testing.T) {
+		b := memory.New()
+		deliveries := subscribe(t, b, 0)
+
+		b.Close()
+		_, ok := <-deliveries
+		assert.False(t, ok, "deliveries should be closed")
+		assert.False(t, b.Ready())
+
+		_, err := b.Publish(context.Background(), broker.Message{Body: []byte("a")})
+		assert.ErrorIs(t, err, broker.ErrClosed)
+		_, err = b.Subscribe(context.Background(), 0)
+		assert.ErrorIs(t, err, broker.ErrClosed)
+	})
+}
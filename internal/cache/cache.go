@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTTL is used when no cache ttl is configured
+const DefaultTTL = 2 * time.Minute
+
+// Cache keeps values by key for a limited time, a failing cache is not critical as values can be read from database
+type Cache interface {
+	// Get will return the cached value of key, ok is false when key is not cached or expired
+	Get(key string) (value []byte, ok bool, err error)
+	// Set will cache the values by their key
+	Set(values map[string][]byte) error
+	// Delete will remove the keys from cache
+	Delete(keys ...string) error
+	// Stats will return the hit, miss and error counts of cache
+	Stats() Stats
+}
+
+// Stats contains the counts of cache lookups and failed operations
+type Stats struct {
+	Backend string `json:"backend"`
+	Hits    int64  `json:"hits"`
+	Misses  int64  `json:"misses"`
+	Errors  int64  `json:"errors"`
+}
+
+// counters counts the cache lookups and failed operations
+type counters struct {
+	hits   atomic.Int64
+	misses atomic.Int64
+	errors atomic.Int64
+}
+
+// lookup will count the result of Get
+func (c *counters) lookup(ok bool, err error) {
+	switch {
+	case err != nil:
+		c.errors.Add(1)
+	case ok:
+		c.hits.Add(1)
+	default:
+		c.misses.Add(1)
+	}
+}
+
+// failed will count the error of an operation and return it
+func (c *counters) failed(err error) error {
+	if err != nil {
+		c.errors.Add(1)
+	}
+	return err
+}
+
+func (c *counters) stats(backend string) Stats {
+	return Stats{
+		Backend: backend,
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Errors:  c.errors.Load(),
+	}
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// DefaultLRUSize is used when no size of in-process cache is configured
+const DefaultLRUSize = 10000
+
+// LRU caches the values in process, the least recently used value is evicted once size values are cached
+// it is used when redis is not available, so the cache is not shared with other consumers
+type LRU struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	// recent keeps the entries from the most to the least recently used
+	recent *list.List
+	counters
+}
+
+type lruEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// NewLRU will create the in-process cache of size values, values expire after ttl and zero ttl keeps them until evicted
+func NewLRU(size int, ttl time.Duration) *LRU {
+	if size <= 0 {
+		size = DefaultLRUSize
+	}
+	return &LRU{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		recent:  list.New(),
+	}
+}
+
+func (c *LRU) Get(key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if ok && c.expired(element.Value.(*lruEntry)) {
+		c.remove(element)
+		ok = false
+	}
+	c.lookup(ok, nil)
+	if !ok {
+		return nil, false, nil
+	}
+
+	c.recent.MoveToFront(element)
+	return element.Value.(*lruEntry).value, true, nil
+}
+
+func (c *LRU) Set(values map[string][]byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expiresAt time.Time
+	if c.ttl > 0 {
+		expiresAt = time.Now().Add(c.ttl)
+	}
+
+	for key, value := range values {
+		if element, ok := c.entries[key]; ok {
+			entry := element.Value.(*lruEntry)
+			entry.value, entry.expiresAt = value, expiresAt
+			c.recent.MoveToFront(element)
+			continue
+		}
+
+		c.entries[key] = c.recent.PushFront(&lruEntry{key: key, value: value, expiresAt: expiresAt})
+		if c.recent.Len() > c.size {
+			c.remove(c.recent.Back())
+		}
+	}
+
+	return nil
+}
+
+func (c *LRU) Delete(keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		if element, ok := c.entries[key]; ok {
+			c.remove(element)
+		}
+	}
+
+	return nil
+}
+
+func (c *LRU) Stats() Stats {
+	return c.stats("lru")
+}
+
+// Len will return the number of cached values, including the expired ones which are not evicted yet
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.recent.Len()
+}
+
+func (c *LRU) expired(entry *lruEntry) bool {
+	return !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt)
+}
+
+// remove will remove the entry, it must be called with the lock held
+func (c *LRU) remove(element *list.Element) {
+	c.recent.Remove(element)
+	delete(c.entries, element.Value.(*lruEntry).key)
+}
@@ -0,0 +1,59 @@
+package cache_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vatsal3003/viswals/internal/cache"
+)
+
+func TestLRU(t *testing.T) {
+	t.Run("Evicts least recently used", func(t *testing.T) {
+		lru := cache.NewLRU(2, 0)
+
+		assert.NoError(t, lru.Set(map[string][]byte{"users:1": []byte("1"), "users:2": []byte("2")}))
+
+		// Reading users:1 makes users:2 the least recently used one
+		value, ok, err := lru.Get("users:1")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, []byte("1"), value)
+
+		assert.NoError(t, lru.Set(map[string][]byte{"users:3": []byte("3")}))
+		assert.Equal(t, 2, lru.Len())
+
+		_, ok, _ = lru.Get("users:2")
+		assert.False(t, ok)
+		_, ok, _ = lru.Get("users:3")
+		assert.True(t, ok)
+
+		assert.Equal(t, cache.Stats{Backend: "lru", Hits: 2, Misses: 1}, lru.Stats())
+	})
+
+	t.Run("Expires after ttl", func(t *testing.T) {
+		lru := cache.NewLRU(10, 20*time.Millisecond)
+
+		assert.NoError(t, lru.Set(map[string][]byte{"users:1": []byte("1")}))
+		_, ok, _ := lru.Get("users:1")
+		assert.True(t, ok)
+
+		time.Sleep(40 * time.Millisecond)
+
+		_, ok, _ = lru.Get("users:1")
+		assert.False(t, ok)
+		assert.Equal(t, 0, lru.Len())
+	})
+
+	t.Run("Deletes keys", func(t *testing.T) {
+		lru := cache.NewLRU(10, 0)
+
+		assert.NoError(t, lru.Set(map[string][]byte{"users:1": []byte("1"), "users:2": []byte("2")}))
+		assert.NoError(t, lru.Delete("users:1", "users:404"))
+
+		_, ok, _ := lru.Get("users:1")
+		assert.False(t, ok)
+		_, ok, _ = lru.Get("users:2")
+		assert.True(t, ok)
+	})
+}
@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis caches the values in redis, so the cache is shared by every consumer
+type Redis struct {
+	client *redis.Client
+	ttl    time.Duration
+	counters
+}
+
+// NewRedis will create the cache over redis client, values expire after ttl and zero ttl keeps them until deleted
+func NewRedis(client *redis.Client, ttl time.Duration) *Redis {
+	return &Redis{client: client, ttl: ttl}
+}
+
+func (c *Redis) Get(key string) ([]byte, bool, error) {
+	value, err := c.client.Get(context.Background(), key).Bytes()
+	if err == redis.Nil {
+		c.lookup(false, nil)
+		return nil, false, nil
+	}
+	c.lookup(err == nil, err)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return value, true, nil
+}
+
+// Set will set the values in one pipeline
+func (c *Redis) Set(values map[string][]byte) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	_, err := c.client.Pipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		for key, value := range values {
+			pipe.Set(context.Background(), key, value, c.ttl)
+		}
+		return nil
+	})
+	return c.failed(err)
+}
+
+func (c *Redis) Delete(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return c.failed(c.client.Del(context.Background(), keys...).Err())
+}
+
+func (c *Redis) Stats() Stats {
+	return c.stats("redis")
+}
@@ -0,0 +1,44 @@
+package cache
+
+import "sync"
+
+// Switching caches the values in the current cache, which can be replaced once, e.g. the in-process cache is used
+// until redis is reachable and then redis is used, the values of the replaced cache are dropped
+type Switching struct {
+	mu      sync.RWMutex
+	current Cache
+}
+
+// NewSwitching will create the cache which uses the initial cache until it is switched
+func NewSwitching(initial Cache) *Switching {
+	return &Switching{current: initial}
+}
+
+// Switch will replace the current cache with the next one
+func (c *Switching) Switch(next Cache) {
+	c.mu.Lock()
+	c.current = next
+	c.mu.Unlock()
+}
+
+func (c *Switching) cache() Cache {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.current
+}
+
+func (c *Switching) Get(key string) ([]byte, bool, error) {
+	return c.cache().Get(key)
+}
+
+func (c *Switching) Set(values map[string][]byte) error {
+	return c.cache().Set(values)
+}
+
+func (c *Switching) Delete(keys ...string) error {
+	return c.cache().Delete(keys...)
+}
+
+func (c *Switching) Stats() Stats {
+	return c.cache().Stats()
+}
@@ -0,0 +1,28 @@
+package cache_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vatsal3003/viswals/internal/cache"
+)
+
+func TestSwitching(t *testing.T) {
+	initial, next := cache.NewLRU(10, 0), cache.NewLRU(10, 0)
+	switching := cache.NewSwitching(initial)
+
+	assert.NoError(t, switching.Set(map[string][]byte{"users:1": []byte("1")}))
+	_, ok, _ := initial.Get("users:1")
+	assert.True(t, ok)
+
+	switching.Switch(next)
+
+	// Values of the replaced cache are dropped and the next cache is used from now on
+	_, ok, err := switching.Get("users:1")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, switching.Set(map[string][]byte{"users:2": []byte("2")}))
+	assert.Equal(t, 1, next.Len())
+	assert.Equal(t, next.Stats(), switching.Stats())
+}
@@ -2,11 +2,17 @@ package consts
 
 var (
 	// ContentType constants
-	ContentTypeGob = "application/x-gob"
+	ContentTypeGob      = "application/x-gob"
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
 
 	// LogLevel constants
 	LogLevelDebug = "DEBUG"
 
+	// Broker constants
+	BrokerRabbitMQ = "rabbitmq"
+	BrokerMemory   = "memory"
+
 	// Response constant
 	StatusSuccess = "success"
 	StatusError   = "error"
@@ -15,8 +21,25 @@ var (
 	LogLevel          = "LOG_LEVEL"
 	MigrateDatabase   = "MIGRATE_DB"
 	ConsumerPort      = "CONSUMER_PORT"
+	ConsumerWorkers   = "CONSUMER_WORKERS"
+	ConsumerBatchSize = "CONSUMER_BATCH_SIZE"
+	ConsumerFlush     = "CONSUMER_FLUSH_INTERVAL"
+	ConsumerConflict  = "CONSUMER_CONFLICT_POLICY"
+	Broker            = "BROKER"
+	LiteMode          = "LITE_MODE"
+	SQLitePath        = "SQLITE_PATH"
 	PostgresConnURL   = "POSTGRES_CONN_URL"
 	RedisConnURL      = "REDIS_CONN_URL"
+	CacheTTL          = "CACHE_TTL"
+	CacheSize         = "CACHE_SIZE"
 	RabbitMQConnURL   = "RABBITMQ_CONN_URL"
 	RabbitMQQueueName = "RABBITMQ_QUEUE_NAME"
+	RabbitMQDLXName   = "RABBITMQ_DLX_NAME"
+	RabbitMQDLQName   = "RABBITMQ_DLQ_NAME"
+	RabbitMQRetries   = "RABBITMQ_RETRY_BACKOFF"
+	RabbitMQCodec     = "RABBITMQ_CODEC"
+	CSVSource         = "CSV_SOURCE"
+	CSVWatchDir       = "CSV_WATCH_DIR"
+	CSVColumnAliases  = "CSV_COLUMN_ALIASES"
+	CSVStateFile      = "CSV_STATE_FILE"
 )
@@ -0,0 +1,129 @@
+package csv
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// checkpointInterval is the number of published rows after which checkpoint is saved to state file
+const checkpointInterval = 1000
+
+// Checkpoint is the ingestion progress of a csv file
+type Checkpoint struct {
+	// Hash is sha256 of the file content, checkpoint is ignored if file is changed
+	Hash string `json:"hash"`
+	// Line and Offset are the line number and byte offset after the last published row
+	Line      int       `json:"line"`
+	Offset    int64     `json:"offset"`
+	Published int       `json:"published"`
+	Completed bool      `json:"completed"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CheckpointStore keeps checkpoints of csv files in a local state file
+type CheckpointStore struct {
+	path        string
+	mu          sync.Mutex
+	checkpoints map[string]Checkpoint
+}
+
+// LoadCheckpoints will load the checkpoints from state file, missing state file is treated as empty
+func LoadCheckpoints(path string) (*CheckpointStore, error) {
+	store := &CheckpointStore{
+		path:        path,
+		checkpoints: make(map[string]Checkpoint),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return store, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &store.checkpoints)
+	if err != nil {
+		return nil, errors.New("invalid checkpoint state file " + path + ":" + err.Error())
+	}
+
+	return store, nil
+}
+
+// Get will return the checkpoint of the file
+func (s *CheckpointStore) Get(file string) (Checkpoint, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	checkpoint, ok := s.checkpoints[checkpointKey(file)]
+	return checkpoint, ok
+}
+
+// Set will update the checkpoint of the file in memory, use Save to write it to state file
+func (s *CheckpointStore) Set(file string, checkpoint Checkpoint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	checkpoint.UpdatedAt = time.Now().UTC()
+	s.checkpoints[checkpointKey(file)] = checkpoint
+}
+
+// Delete will remove the checkpoint of the file in memory, use Save to write it to state file
+func (s *CheckpointStore) Delete(file string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.checkpoints, checkpointKey(file))
+}
+
+// Save will atomically write all checkpoints to the state file
+func (s *CheckpointStore) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.MarshalIndent(s.checkpoints, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpFile := s.path + ".tmp"
+	err = os.WriteFile(tmpFile, data, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, s.path)
+}
+
+// checkpointKey uses absolute path, so the same file is found from any working directory
+func checkpointKey(file string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return file
+	}
+	return abs
+}
+
+// hashFile will return the hex encoded sha256 of file content and seek the file back to start
+func hashFile(file *os.File) (string, error) {
+	hash := sha256.New()
+
+	_, err := io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,46 @@
+package csv_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vatsal3003/viswals/internal/csv"
+)
+
+func TestCheckpointStore(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	// Missing state file is treated as empty
+	store, err := csv.LoadCheckpoints(stateFile)
+	assert.NoError(t, err)
+
+	_, ok := store.Get("users.csv")
+	assert.False(t, ok)
+
+	store.Set("users.csv", csv.Checkpoint{Hash: "abc", Line: 10, Offset: 512, Published: 9})
+	store.Set("other.csv", csv.Checkpoint{Hash: "def", Completed: true})
+	store.Delete("other.csv")
+	assert.NoError(t, store.Save())
+
+	// Checkpoints are loaded back from state file
+	store, err = csv.LoadCheckpoints(stateFile)
+	assert.NoError(t, err)
+
+	checkpoint, ok := store.Get("users.csv")
+	assert.True(t, ok)
+	assert.Equal(t, "abc", checkpoint.Hash)
+	assert.Equal(t, 10, checkpoint.Line)
+	assert.Equal(t, int64(512), checkpoint.Offset)
+	assert.Equal(t, 9, checkpoint.Published)
+	assert.False(t, checkpoint.UpdatedAt.IsZero())
+
+	_, ok = store.Get("other.csv")
+	assert.False(t, ok)
+
+	// Corrupted state file is reported
+	assert.NoError(t, os.WriteFile(stateFile, []byte("{"), 0o644))
+	_, err = csv.LoadCheckpoints(stateFile)
+	assert.Error(t, err)
+}
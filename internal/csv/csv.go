@@ -1,53 +1,325 @@
 package csv
 
 import (
+	"context"
 	"encoding/csv"
 	"errors"
 	"io"
 	"os"
+	"slices"
+	"strconv"
 
-	"github.com/vatsal3003/viswals/internal/database"
-	"github.com/vatsal3003/viswals/internal/rabbitmq"
+	"github.com/vatsal3003/viswals/internal/pipeline"
+	"github.com/vatsal3003/viswals/models"
 	"go.uber.org/zap"
 )
 
-// IngestCSV will read from csv and publish message to rabbitmq
-func IngestCSV(logger *zap.Logger, rmq *rabbitmq.RabbitMQ) error {
-	csvFile, err := os.Open("/users.csv")
-	// csvFile, err := os.Open("../../csvs/demo.csv")
+// Options configures how csv files are read
+type Options struct {
+	// Aliases maps alternative column names to csv tag of models.User
+	Aliases map[string]string
+	// RejectsDir is the directory of rejects csv files, defaults to the directory of ingested file
+	RejectsDir string
+	// Checkpoints keeps the progress of files to resume them, nil disables checkpoints
+	Checkpoints *CheckpointStore
+	// FromScratch ignores the existing checkpoints and ingests files from start
+	FromScratch bool
+}
+
+// Stats contains the row counts of an ingested csv file
+type Stats struct {
+	pipeline.PublishSummary
+	Rejected int
+	// RejectsFile is the path of rejects csv file, empty if no row is rejected
+	RejectsFile string
+}
+
+// FileReport contains the result of ingesting a single csv file
+type FileReport struct {
+	Path string
+	Stats
+	Err error
+}
+
+// IngestFiles will ingest each csv file in turn and return report of every file
+// the files left when the context is cancelled are not ingested and have no report
+func IngestFiles(ctx context.Context, logger *zap.Logger, producer *pipeline.Producer, files []string, opts Options) []FileReport {
+	reports := make([]FileReport, 0, len(files))
+
+	for _, file := range files {
+		if ctx.Err() != nil {
+			break
+		}
+
+		logger.Info("ingesting csv file", zap.String("file", file))
+
+		stats, err := IngestCSV(ctx, logger, producer, file, opts)
+		reports = append(reports, FileReport{
+			Path:  file,
+			Stats: stats,
+			Err:   err,
+		})
+	}
+
+	return reports
+}
+
+// IngestCSV will read from csv and publish message to broker
+// invalid rows are written to the rejects csv file with line number and reason instead of being published
+// with checkpoints enabled, ingestion resumes after the last published row of previous run of the same file
+// when the context is cancelled the ingestion stops and the checkpoint is saved after the last confirmed row
+func IngestCSV(ctx context.Context, logger *zap.Logger, producer *pipeline.Producer, path string, opts Options) (Stats, error) {
+	var stats Stats
+
+	csvFile, err := os.Open(path)
 	if err != nil {
 		logger.Error("failed to open csv file to ingest data:" + err.Error())
-		return err
+		return stats, err
 	}
 	defer csvFile.Close()
 
-	// Initialize and configure csv reader
-	csvReader := csv.NewReader(csvFile)
+	// Find the checkpoint of previous run
+	var checkpoint Checkpoint
+	if opts.Checkpoints != nil {
+		checkpoint, err = loadCheckpoint(logger, opts, csvFile, path)
+		if err != nil {
+			return stats, err
+		}
+		if checkpoint.Completed {
+			logger.Info("csv file is already ingested, skipping it", zap.String("file", path), zap.Int("published", checkpoint.Published))
+			return stats, nil
+		}
+	}
 
-	csvReader.Comment = '#'
-	csvReader.ReuseRecord = true // Keeping it true to reuse the previous slice for storing the new record for better performance
+	// Initialize and configure csv reader
+	csvReader := newCSVReader(csvFile)
 
 	// Read headers
 	row, err := csvReader.Read()
 	if err != nil {
 		if errors.Is(err, io.EOF) {
-			return errors.New("csv file is empty")
+			return stats, errors.New("csv file is empty")
 		}
-		return err
+		return stats, err
+	}
+
+	fieldsPerRecord := len(row) // expected fields per row
+
+	// Map the columns by header names, so nothing is published for a file with invalid header
+	mapping, err := NewColumnMapping(row, opts.Aliases)
+	if err != nil {
+		logger.Error("invalid csv header:" + err.Error())
+		return stats, err
+	}
+
+	reader := &userReader{
+		logger:     logger,
+		csvReader:  csvReader,
+		mapping:    mapping,
+		rejects:    newRejectWriter(path, opts.RejectsDir, row, checkpoint.Offset != 0),
+		path:       path,
+		store:      opts.Checkpoints,
+		checkpoint: checkpoint,
+	}
+
+	// Continue reading after the last published row
+	if checkpoint.Offset != 0 {
+		logger.Info("resuming csv file from checkpoint", zap.String("file", path), zap.Int("line", checkpoint.Line), zap.Int("published", checkpoint.Published))
+
+		err = reader.rejects.Trim(checkpoint.Line)
+		if err != nil {
+			logger.Error("failed to trim rejects csv file to checkpoint:" + err.Error())
+			return stats, err
+		}
+
+		_, err = csvFile.Seek(checkpoint.Offset, io.SeekStart)
+		if err != nil {
+			logger.Error("failed to seek csv file to checkpoint:" + err.Error())
+			return stats, err
+		}
+
+		reader.csvReader = newCSVReader(csvFile)
+		reader.lineBase = checkpoint.Line
+		reader.offsetBase = checkpoint.Offset
+	}
+
+	reader.csvReader.FieldsPerRecord = fieldsPerRecord
+
+	// Publish message to broker
+	stats.PublishSummary, err = producer.Publish(ctx, logger, reader)
+
+	// Save the progress, completed file is skipped in next run
+	if opts.Checkpoints != nil {
+		reader.checkpoint.Completed = err == nil
+		saveErr := reader.saveCheckpoint()
+		if saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}
+
+	closeErr := reader.rejects.Close()
+	if closeErr != nil {
+		logger.Error("failed to write rejects csv file:" + closeErr.Error())
+		if err == nil {
+			err = closeErr
+		}
+	}
+
+	stats.Rejected = reader.rejects.count
+	if stats.Rejected != 0 {
+		stats.RejectsFile = reader.rejects.path
+		logger.Warn("csv rows rejected", zap.String("file", path), zap.Int("rejected", stats.Rejected), zap.String("rejects_file", stats.RejectsFile))
+	}
+
+	return stats, err
+}
+
+// loadCheckpoint will return the checkpoint of file, empty checkpoint is returned for new or changed file
+func loadCheckpoint(logger *zap.Logger, opts Options, csvFile *os.File, path string) (Checkpoint, error) {
+	hash, err := hashFile(csvFile)
+	if err != nil {
+		logger.Error("failed to hash csv file:" + err.Error())
+		return Checkpoint{}, err
+	}
+
+	checkpoint, ok := opts.Checkpoints.Get(path)
+	if !ok || opts.FromScratch {
+		return Checkpoint{Hash: hash}, nil
+	}
+
+	if checkpoint.Hash != hash {
+		logger.Warn("csv file is changed since last checkpoint, ingesting it from scratch", zap.String("file", path))
+		return Checkpoint{Hash: hash}, nil
+	}
+
+	return checkpoint, nil
+}
+
+func newCSVReader(r io.Reader) *csv.Reader {
+	csvReader := csv.NewReader(r)
+
+	csvReader.Comment = '#'
+	csvReader.ReuseRecord = true // Keeping it true to reuse the previous slice for storing the new record for better performance
+
+	return csvReader
+}
+
+// userReader reads users from csv rows using the column mapping
+type userReader struct {
+	logger    *zap.Logger
+	csvReader *csv.Reader
+	mapping   *ColumnMapping
+	rejects   *rejectWriter
+	user      models.User
+	record    pipeline.Record
+
+	// lineBase and offsetBase are added to the position of csv reader when resumed from checkpoint
+	lineBase   int
+	offsetBase int64
+
+	path       string
+	store      *CheckpointStore
+	checkpoint Checkpoint
+}
+
+// Read will return the next valid user of csv file, invalid rows are written to rejects file
+func (r *userReader) Read() (*pipeline.Record, error) {
+	for {
+		row, err := r.csvReader.Read()
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, err
+			}
+
+			// Reject the row having partial data or invalid csv syntax
+			err = r.reject(r.lineBase+parseErr.StartLine, parseErr.Err.Error(), row)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		line, _ := r.csvReader.FieldPos(0)
+		line += r.lineBase
+
+		err = r.mapping.Decode(row, &r.user)
+		if err == nil {
+			err = models.ValidateUser(&r.user)
+		}
+		if err != nil {
+			err = r.reject(line, err.Error(), row)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Position after the row, last field is used as a row can span multiple lines
+		endLine, _ := r.csvReader.FieldPos(len(row) - 1)
+		r.record = pipeline.Record{
+			User:   &r.user,
+			Row:    slices.Clone(row),
+			Source: r.path,
+			Line:   r.lineBase + endLine,
+			Offset: r.offsetBase + r.csvReader.InputOffset(),
+		}
+
+		return &r.record, nil
 	}
+}
 
-	csvReader.FieldsPerRecord = len(row) // expected fields per row
+// Published will move the checkpoint after the confirmed record
+func (r *userReader) Published(record *pipeline.Record) {
+	r.advance(record)
+}
 
-	// Publish message to rabbitmq
-	rmq.Publish(logger, csvReader)
+// Failed will write the record which is not confirmed by broker to rejects file and move the checkpoint after it
+func (r *userReader) Failed(record *pipeline.Record, err error) {
+	_ = r.reject(record.Line, err.Error(), record.Row)
+	r.advance(record)
+}
+
+func (r *userReader) advance(record *pipeline.Record) {
+	if r.store == nil {
+		return
+	}
+
+	r.checkpoint.Line = record.Line
+	r.checkpoint.Offset = record.Offset
+	r.checkpoint.Published++
+
+	if r.checkpoint.Published%checkpointInterval == 0 {
+		_ = r.saveCheckpoint()
+	}
+}
+
+func (r *userReader) saveCheckpoint() error {
+	r.store.Set(r.path, r.checkpoint)
+
+	err := r.store.Save()
+	if err != nil {
+		r.logger.Error("failed to save csv checkpoint:" + err.Error())
+		return err
+	}
 
 	return nil
 }
 
-// DigestCSV will consume messages from rabbitmq
-func DigestCSV(logger *zap.Logger, rmq *rabbitmq.RabbitMQ, db *database.Database) {
-	usersChan := make(chan []byte, 50)
+func (r *userReader) reject(line int, reason string, row []string) error {
+	r.logger.Debug("rejecting csv row " + strconv.Itoa(line) + ":" + reason)
+
+	err := r.rejects.Reject(line, reason, row)
+	if err != nil {
+		r.logger.Error("failed to write rejected csv row:" + err.Error())
+		return err
+	}
+
+	return nil
+}
 
-	// Start consuming messages from rabbitmq
-	rmq.Consume(logger, db, usersChan)
+// DigestCSV will consume messages from broker and store the users until the broker is closed
+func DigestCSV(logger *zap.Logger, consumer *pipeline.Consumer) {
+	// Start consuming messages from broker
+	_ = consumer.Run(context.Background(), logger)
 }
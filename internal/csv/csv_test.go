@@ -0,0 +1,85 @@
+package csv_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vatsal3003/viswals/internal/broker/memory"
+	"github.com/vatsal3003/viswals/internal/csv"
+	"github.com/vatsal3003/viswals/internal/pipeline"
+	"go.uber.org/zap"
+)
+
+func TestIngestCSVResume(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "users.csv")
+	rows := []string{
+		usersHeader,
+		"0,Invalid,User,invalid@gmail.edu,1361367320000,-1,-1,-1\n",
+		"42,Grace,Taylor,GraceTaylor1951@inbox.edu,1361459822000,-1,-1,-1\n",
+		"31,Emily,\"Tamm\nJr\",EmilyTamm@gmail.edu,1361367320000,-1,-1,-1\n",
+		"13,Partial,Row\n",
+		"8,Hanah,Schmidt,Hanah_Schmidt1965@gmail.edu,1361218223000,-1,-1,-1\n",
+	}
+	content := strings.Join(rows, "")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+	logger := zap.NewNop()
+	checkpoints, err := csv.LoadCheckpoints(filepath.Join(dir, "checkpoints.json"))
+	assert.NoError(t, err)
+	opts := csv.Options{Checkpoints: checkpoints}
+
+	// First run rejects every invalid row, then checkpoint is moved back after user 42
+	// like a run stopped before the later rows are confirmed
+	stats, err := csv.IngestCSV(context.Background(), logger, pipeline.NewProducer(memory.New(), pipeline.ProducerOptions{}), path, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, stats.Rejected)
+
+	checkpoint, ok := checkpoints.Get(path)
+	assert.True(t, ok)
+	checkpoint.Line = 3
+	checkpoint.Offset = int64(len(strings.Join(rows[:3], "")))
+	checkpoint.Published = 1
+	checkpoint.Completed = false
+	checkpoints.Set(path, checkpoint)
+
+	messageBroker := memory.New()
+	defer messageBroker.Close()
+
+	stats, err = csv.IngestCSV(context.Background(), logger, pipeline.NewProducer(messageBroker, pipeline.ProducerOptions{Codec: pipeline.JSONCodec}), path, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, stats.Confirmed)
+	assert.Equal(t, 1, stats.Rejected)
+
+	// Rows are read after the checkpoint with the lines of whole file
+	deliveries, err := messageBroker.Subscribe(context.Background(), 0)
+	assert.NoError(t, err)
+
+	var lines []int
+	for range 2 {
+		delivery := <-deliveries
+		var envelope pipeline.Envelope
+		assert.NoError(t, pipeline.JSONCodec.Decode(delivery.Message().Body, &envelope))
+		lines = append(lines, envelope.Line)
+	}
+	assert.Equal(t, []int{5, 7}, lines)
+
+	checkpoint, _ = checkpoints.Get(path)
+	assert.Equal(t, 7, checkpoint.Line)
+	assert.Equal(t, int64(len(content)), checkpoint.Offset)
+	assert.Equal(t, 3, checkpoint.Published)
+	assert.True(t, checkpoint.Completed)
+
+	// Row rejected before the checkpoint is kept and the row after it is not rejected twice
+	rejects, err := os.ReadFile(stats.RejectsFile)
+	assert.NoError(t, err)
+	var rejectedLines []string
+	for _, line := range strings.Split(strings.TrimSpace(string(rejects)), "\n")[1:] {
+		rejectedLines = append(rejectedLines, strings.SplitN(line, ",", 2)[0])
+	}
+	assert.Equal(t, []string{"2", "6"}, rejectedLines)
+}
@@ -0,0 +1,204 @@
+package csv
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vatsal3003/viswals/internal/utils"
+	"github.com/vatsal3003/viswals/models"
+)
+
+// csvTag is the struct tag of models.User used as column name
+const csvTag = "csv"
+
+// optionalColumns can be missing in csv file, their fields are left empty
+var optionalColumns = map[string]bool{
+	"deleted_at":     true,
+	"merged_at":      true,
+	"parent_user_id": true,
+}
+
+// userField is a field of models.User which is filled from a csv column
+type userField struct {
+	column string
+	index  []int
+}
+
+// userFields are the fields of models.User having csv tag, in struct order
+var userFields = func() []userField {
+	var fields []userField
+
+	userType := reflect.TypeOf(models.User{})
+	for i := 0; i < userType.NumField(); i++ {
+		field := userType.Field(i)
+		column := field.Tag.Get(csvTag)
+		if column == "" || column == "-" {
+			continue
+		}
+		fields = append(fields, userField{column: column, index: field.Index})
+	}
+
+	return fields
+}()
+
+// ColumnMapping maps columns of csv file to the fields of models.User
+type ColumnMapping struct {
+	// positions contains column position of every user field, -1 if the column is missing
+	positions []int
+}
+
+// NewColumnMapping will build the mapping from header row of csv file
+// aliases maps alternative column names to csv tag of models.User, e.g. email => email_address
+// header with unknown, duplicate or missing required columns is rejected
+func NewColumnMapping(header []string, aliases map[string]string) (*ColumnMapping, error) {
+	columns := make(map[string]int, len(header))
+
+	var unknown []string
+	for i, name := range header {
+		name = normalizeColumn(name, i)
+		if alias, ok := aliases[name]; ok {
+			name = alias
+		}
+
+		if !isUserColumn(name) {
+			unknown = append(unknown, name)
+			continue
+		}
+		if _, ok := columns[name]; ok {
+			return nil, errors.New("duplicate column " + name + " in csv header")
+		}
+		columns[name] = i
+	}
+
+	if len(unknown) != 0 {
+		return nil, errors.New("unknown columns in csv header: " + strings.Join(unknown, ", "))
+	}
+
+	mapping := &ColumnMapping{positions: make([]int, len(userFields))}
+
+	var missing []string
+	for i, field := range userFields {
+		position, ok := columns[field.column]
+		if !ok {
+			if !optionalColumns[field.column] {
+				missing = append(missing, field.column)
+			}
+			position = -1
+		}
+		mapping.positions[i] = position
+	}
+
+	if len(missing) != 0 {
+		return nil, errors.New("missing required columns in csv header: " + strings.Join(missing, ", "))
+	}
+
+	return mapping, nil
+}
+
+// Decode will fill the user from csv row according to the mapping
+func (m *ColumnMapping) Decode(row []string, user *models.User) error {
+	*user = models.User{}
+
+	value := reflect.ValueOf(user).Elem()
+	for i, field := range userFields {
+		position := m.positions[i]
+		if position < 0 {
+			continue
+		}
+
+		err := setField(value.FieldByIndex(field.index), strings.TrimSpace(row[position]))
+		if err != nil {
+			return errors.New("invalid " + field.column + ": " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// setField parses the csv value according to the kind of field
+// -1 or empty value is treated as null for pointer fields
+func setField(field reflect.Value, raw string) error {
+	if field.Kind() == reflect.Pointer {
+		if raw == "-1" || raw == "" {
+			field.SetZero()
+			return nil
+		}
+		ptr := reflect.New(field.Type().Elem())
+		err := setField(ptr.Elem(), raw)
+		if err != nil {
+			return err
+		}
+		field.Set(ptr)
+		return nil
+	}
+
+	switch {
+	case field.Type() == timeType:
+		t, err := utils.MillisToTime(raw)
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return errors.New("value is required")
+		}
+		field.Set(reflect.ValueOf(*t))
+	case field.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case field.Kind() == reflect.String:
+		field.SetString(raw)
+	default:
+		return errors.New("unsupported field type " + field.Type().String())
+	}
+
+	return nil
+}
+
+// ParseAliases will parse comma separated aliases in alias=column format
+func ParseAliases(aliases string) (map[string]string, error) {
+	result := make(map[string]string)
+
+	for _, pair := range strings.Split(aliases, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		alias, column, ok := strings.Cut(pair, "=")
+		alias, column = normalizeColumn(alias, 1), normalizeColumn(column, 1)
+		if !ok || alias == "" || column == "" {
+			return nil, errors.New("invalid column alias " + pair + ", expected alias=column")
+		}
+		if !isUserColumn(column) {
+			return nil, errors.New("invalid column alias " + pair + ", unknown column " + column)
+		}
+		result[alias] = column
+	}
+
+	return result, nil
+}
+
+func isUserColumn(name string) bool {
+	for _, field := range userFields {
+		if field.column == name {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeColumn trims and lowercases the column name, first column can contain UTF-8 BOM
+func normalizeColumn(name string, position int) string {
+	if position == 0 {
+		name = strings.TrimPrefix(name, "\ufeff")
+	}
+	return strings.ToLower(strings.TrimSpace(name))
+}
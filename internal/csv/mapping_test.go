@@ -0,0 +1,100 @@
+package csv_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vatsal3003/viswals/internal/csv"
+	"github.com/vatsal3003/viswals/models"
+)
+
+func TestNewColumnMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  []string
+		aliases map[string]string
+		wantErr string
+	}{
+		{
+			name:   "All columns",
+			header: []string{"id", "first_name", "last_name", "email_address", "created_at", "deleted_at", "merged_at", "parent_user_id"},
+		},
+		{
+			name:   "Reordered columns without optional ones",
+			header: []string{"email_address", "ID", " last_name", "first_name", "created_at"},
+		},
+		{
+			name:    "Aliased column",
+			header:  []string{"id", "first_name", "last_name", "email", "created_at"},
+			aliases: map[string]string{"email": "email_address"},
+		},
+		{
+			name:    "Unknown column",
+			header:  []string{"id", "first_name", "last_name", "email_address", "created_at", "phone"},
+			wantErr: "unknown columns in csv header: phone",
+		},
+		{
+			name:    "Missing required column",
+			header:  []string{"id", "first_name", "email_address"},
+			wantErr: "missing required columns in csv header: last_name, created_at",
+		},
+		{
+			name:    "Duplicate column",
+			header:  []string{"id", "id", "first_name", "last_name", "email_address", "created_at"},
+			wantErr: "duplicate column id in csv header",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapping, err := csv.NewColumnMapping(tt.header, tt.aliases)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotNil(t, mapping)
+		})
+	}
+}
+
+func TestColumnMappingDecode(t *testing.T) {
+	mapping, err := csv.NewColumnMapping(
+		[]string{"parent_user_id", "email", "created_at", "last_name", "first_name", "id", "merged_at"},
+		map[string]string{"email": "email_address"},
+	)
+	assert.NoError(t, err)
+
+	var user models.User
+	err = mapping.Decode([]string{"118437", "grace@inbox.edu", "1361459822000", "Taylor", "Grace", "13", "-1"}, &user)
+	assert.NoError(t, err)
+
+	parentUserID := 118437
+	assert.Equal(t, models.User{
+		ID:           13,
+		FirstName:    "Grace",
+		LastName:     "Taylor",
+		EmailAddress: "grace@inbox.edu",
+		CreatedAt:    time.UnixMilli(1361459822000),
+		ParentUserID: &parentUserID,
+	}, user)
+
+	err = mapping.Decode([]string{"-1", "grace@inbox.edu", "-1", "Taylor", "Grace", "13", "-1"}, &user)
+	assert.EqualError(t, err, "invalid created_at: value is required")
+
+	err = mapping.Decode([]string{"-1", "grace@inbox.edu", "1361459822000", "Taylor", "Grace", "abc", "-1"}, &user)
+	assert.Error(t, err)
+}
+
+func TestParseAliases(t *testing.T) {
+	aliases, err := csv.ParseAliases("email=email_address, Fname = first_name")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"email": "email_address", "fname": "first_name"}, aliases)
+
+	_, err = csv.ParseAliases("email")
+	assert.Error(t, err)
+
+	_, err = csv.ParseAliases("phone=phone_number")
+	assert.Error(t, err)
+}
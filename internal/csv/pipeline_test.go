@@ -0,0 +1,164 @@
+package csv_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vatsal3003/viswals/internal/broker"
+	"github.com/vatsal3003/viswals/internal/broker/memory"
+	"github.com/vatsal3003/viswals/internal/csv"
+	"github.com/vatsal3003/viswals/internal/pipeline"
+	"github.com/vatsal3003/viswals/internal/service/userservice"
+	"github.com/vatsal3003/viswals/models"
+	"go.uber.org/zap"
+)
+
+// memoryStore keeps the stored users in memory, storing failID fails until failures are used up
+// and storing a user whose parent is not stored fails like the database does
+type memoryStore struct {
+	mu       sync.Mutex
+	users    map[int]*models.User
+	lineages map[int]models.UserLineage
+	failID   int
+	failures int
+}
+
+func (s *memoryStore) StoreUsers(users []*models.User, lineages []models.UserLineage) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]int, 0, len(users))
+	for _, user := range users {
+		if user.ID == s.failID && s.failures > 0 {
+			return nil, errors.New("connection reset")
+		}
+		if user.ParentUserID != nil && s.users[*user.ParentUserID] == nil && !slices.ContainsFunc(users, func(parent *models.User) bool {
+			return parent.ID == *user.ParentUserID
+		}) {
+			return nil, userservice.ErrMissingParent
+		}
+		ids = append(ids, user.ID)
+	}
+	for i, user := range users {
+		s.users[user.ID] = user
+		s.lineages[user.ID] = lineages[i]
+	}
+
+	return ids, nil
+}
+
+func (s *memoryStore) StoreUser(user *models.User, lineage models.UserLineage) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user.ID == s.failID && s.failures > 0 {
+		s.failures--
+		return false, errors.New("connection reset")
+	}
+	if user.ParentUserID != nil && s.users[*user.ParentUserID] == nil {
+		return false, userservice.ErrMissingParent
+	}
+	s.users[user.ID] = user
+	s.lineages[user.ID] = lineage
+
+	return true, nil
+}
+
+func (s *memoryStore) CacheUsers(refreshed map[int][]byte, invalidated []int) {}
+
+func (s *memoryStore) ids() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]int, 0, len(s.users))
+	for id := range s.users {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestPipeline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.csv")
+	err := os.WriteFile(path, []byte(
+		"id,first_name,last_name,email_address,created_at,deleted_at,merged_at,parent_user_id\n"+
+			"42,Grace,Taylor,GraceTaylor1951@inbox.edu,1361459822000,-1,-1,8\n"+
+			"31,Emily,Tamm,EmilyTamm@gmail.edu,1361367320000,-1,-1,-1\n"+
+			"0,Invalid,User,invalid@gmail.edu,1361367320000,-1,-1,-1\n"+
+			"8,Hanah,Schmidt,Hanah_Schmidt1965@gmail.edu,1361218223000,-1,-1,-1\n",
+	), 0o644)
+	assert.NoError(t, err)
+
+	logger := zap.NewNop()
+	messageBroker := memory.New()
+	defer messageBroker.Close()
+
+	store := &memoryStore{
+		users:    make(map[int]*models.User),
+		lineages: make(map[int]models.UserLineage),
+		failID:   31,
+		failures: 1,
+	}
+
+	for _, codec := range []string{"gob", "json", "protobuf"} {
+		codec, err := pipeline.CodecByName(codec)
+		assert.NoError(t, err)
+
+		producer := pipeline.NewProducer(messageBroker, pipeline.ProducerOptions{Codec: codec})
+		stats, err := csv.IngestCSV(context.Background(), logger, producer, path, csv.Options{RejectsDir: t.TempDir()})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, stats.Confirmed)
+		assert.Equal(t, 1, stats.Rejected)
+	}
+
+	// Message which can not be decoded is dead lettered without retries
+	_, err = messageBroker.Publish(context.Background(), broker.Message{
+		ContentType: "text/plain",
+		Body:        []byte("not a user"),
+	})
+	assert.NoError(t, err)
+
+	consumer := pipeline.NewConsumer(messageBroker, store, pipeline.ConsumerOptions{
+		Workers:       2,
+		BatchSize:     4,
+		FlushInterval: 10 * time.Millisecond,
+		RetryBackoff:  []time.Duration{10 * time.Millisecond},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- consumer.Run(ctx, logger)
+	}()
+
+	assert.Eventually(t, func() bool {
+		depth, _ := messageBroker.QueueDepth()
+		return messageBroker.Idle() && depth == 0 && len(store.ids()) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-done)
+
+	assert.Equal(t, []int{8, 31, 42}, store.ids())
+	assert.Equal(t, 0, store.failures, "failed user should be retried")
+
+	lineage := store.lineages[42]
+	assert.Equal(t, path, lineage.Source)
+	assert.Equal(t, 2, lineage.Line)
+	assert.Equal(t, pipeline.SchemaVersion, lineage.SchemaVersion)
+	assert.NotEmpty(t, lineage.BatchID)
+	assert.NotEqual(t, "GraceTaylor1951@inbox.edu", store.users[42].EmailAddress, "email address should be encrypted")
+
+	deadLetters := messageBroker.DeadLetters()
+	if assert.Len(t, deadLetters, 1) {
+		assert.Equal(t, "text/plain", deadLetters[0].ContentType)
+		assert.NotEmpty(t, deadLetters[0].Headers[pipeline.HeaderFailureReason])
+	}
+}
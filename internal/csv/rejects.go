@@ -0,0 +1,139 @@
+package csv
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// rejectsSuffix replaces the .csv extension of the ingested file for its rejects file
+const rejectsSuffix = ".rejects.csv"
+
+// isRejectsFile will report whether the file is the rejects file of an ingested file,
+// rejects files are never ingested, as their line and reason columns can not be mapped to a user
+func isRejectsFile(file string) bool {
+	return strings.HasSuffix(file, rejectsSuffix)
+}
+
+// rejectWriter writes the invalid rows with line number and reason to the rejects csv file
+// the file is created on first rejected row, so valid files do not leave empty rejects file
+type rejectWriter struct {
+	path   string
+	header []string
+	// appendRows keeps the rows rejected by previous run of resumed file
+	appendRows bool
+	file       *os.File
+	writer     *csv.Writer
+	count      int
+}
+
+// newRejectWriter will return writer for the rejects file of the csv file, dir defaults to the directory of csv file
+func newRejectWriter(path, dir string, header []string, appendRows bool) *rejectWriter {
+	if dir == "" {
+		dir = filepath.Dir(path)
+	}
+
+	return &rejectWriter{
+		path:       filepath.Join(dir, strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))+rejectsSuffix),
+		header:     append([]string{"line", "reason"}, header...),
+		appendRows: appendRows,
+	}
+}
+
+// Trim will drop the rows of rejects file rejected after the line by previous run,
+// the rows after checkpoint are read again on resume, so they would be rejected twice otherwise
+func (w *rejectWriter) Trim(line int) error {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1 // Rejected rows can have any number of fields
+	records, err := reader.ReadAll()
+	if err != nil {
+		return errors.New("invalid rejects csv file " + w.path + ":" + err.Error())
+	}
+
+	kept := records[:min(1, len(records))] // header
+	for _, record := range records[len(kept):] {
+		rowLine, err := strconv.Atoi(record[0])
+		if err != nil || rowLine <= line {
+			kept = append(kept, record)
+		}
+	}
+
+	if len(kept) == len(records) {
+		return nil
+	}
+
+	// Rejects file is not left with header only
+	if len(kept) == 1 {
+		return os.Remove(w.path)
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	err = writer.WriteAll(kept)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(w.path, buf.Bytes(), 0o644)
+}
+
+// Reject will write the row with its line number and rejection reason
+func (w *rejectWriter) Reject(line int, reason string, row []string) error {
+	if w.writer == nil {
+		flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if w.appendRows {
+			flag = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+
+		file, err := os.OpenFile(w.path, flag, 0o644)
+		if err != nil {
+			return err
+		}
+		w.file = file
+		w.writer = csv.NewWriter(file)
+
+		// Header is written only once at the start of file
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		if info.Size() == 0 {
+			err = w.writer.Write(w.header)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	w.count++
+
+	return w.writer.Write(append([]string{strconv.Itoa(line), reason}, row...))
+}
+
+// Close will flush and close the rejects file if any row is rejected
+func (w *rejectWriter) Close() error {
+	if w.writer == nil {
+		return nil
+	}
+
+	w.writer.Flush()
+	err := w.writer.Error()
+	if err != nil {
+		w.file.Close()
+		return err
+	}
+
+	return w.file.Close()
+}
@@ -0,0 +1,85 @@
+package csv
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ResolveFiles will expand the sources into list of csv files
+// source can be a file path, glob pattern or directory (all .csv files in it are picked)
+// rejects files written next to the ingested files are skipped by directories and glob patterns
+func ResolveFiles(sources []string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+
+	add := func(file string) {
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+
+	for _, source := range sources {
+		source = strings.TrimSpace(source)
+		if source == "" {
+			continue
+		}
+
+		info, err := os.Stat(source)
+		if err == nil {
+			if !info.IsDir() {
+				add(source)
+				continue
+			}
+
+			// Pick every csv file of the directory
+			matches, err := filepath.Glob(filepath.Join(source, "*.csv"))
+			if err != nil {
+				return nil, err
+			}
+			sort.Strings(matches)
+			for _, match := range matches {
+				if !isRejectsFile(match) {
+					add(match)
+				}
+			}
+			continue
+		}
+
+		// Source does not exist as it is, so treat it as glob pattern
+		matches, err := filepath.Glob(source)
+		if err != nil {
+			return nil, errors.New("invalid csv source " + source + ":" + err.Error())
+		}
+		matches = slices.DeleteFunc(matches, isRejectsFile)
+		if len(matches) == 0 {
+			return nil, errors.New("no csv file found for source " + source)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			add(match)
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New("no csv file to ingest")
+	}
+
+	return files, nil
+}
+
+// SplitSources will split comma separated sources
+func SplitSources(sources string) []string {
+	var result []string
+	for _, source := range strings.Split(sources, ",") {
+		source = strings.TrimSpace(source)
+		if source != "" {
+			result = append(result, source)
+		}
+	}
+	return result
+}
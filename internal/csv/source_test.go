@@ -0,0 +1,83 @@
+package csv_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vatsal3003/viswals/internal/csv"
+)
+
+func TestResolveFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.csv", "a.csv", "a.rejects.csv", "notes.txt"} {
+		err := os.WriteFile(filepath.Join(dir, name), []byte("id\n"), 0o644)
+		assert.NoError(t, err)
+	}
+
+	tests := []struct {
+		name    string
+		sources []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "Single file",
+			sources: []string{filepath.Join(dir, "a.csv")},
+			want:    []string{filepath.Join(dir, "a.csv")},
+		},
+		{
+			name:    "Directory",
+			sources: []string{dir},
+			want:    []string{filepath.Join(dir, "a.csv"), filepath.Join(dir, "b.csv")},
+		},
+		{
+			name:    "Glob pattern",
+			sources: []string{filepath.Join(dir, "*.txt")},
+			want:    []string{filepath.Join(dir, "notes.txt")},
+		},
+		{
+			name:    "Glob pattern skips rejects files",
+			sources: []string{filepath.Join(dir, "a*.csv")},
+			want:    []string{filepath.Join(dir, "a.csv")},
+		},
+		{
+			name:    "Rejects file given as it is",
+			sources: []string{filepath.Join(dir, "a.rejects.csv")},
+			want:    []string{filepath.Join(dir, "a.rejects.csv")},
+		},
+		{
+			name:    "Duplicate sources",
+			sources: []string{filepath.Join(dir, "b.csv"), dir},
+			want:    []string{filepath.Join(dir, "b.csv"), filepath.Join(dir, "a.csv")},
+		},
+		{
+			name:    "Missing file",
+			sources: []string{filepath.Join(dir, "missing.csv")},
+			wantErr: true,
+		},
+		{
+			name:    "No sources",
+			sources: nil,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := csv.ResolveFiles(tt.sources)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, files)
+		})
+	}
+}
+
+func TestSplitSources(t *testing.T) {
+	assert.Equal(t, []string{"a.csv", "dir", "*.csv"}, csv.SplitSources(" a.csv,dir,, *.csv "))
+	assert.Nil(t, csv.SplitSources(""))
+}
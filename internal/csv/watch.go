@@ -0,0 +1,217 @@
+package csv
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vatsal3003/viswals/internal/pipeline"
+	"go.uber.org/zap"
+)
+
+const (
+	// ProcessedDir and FailedDir are created inside the inbox directory
+	ProcessedDir = "processed"
+	FailedDir    = "failed"
+
+	// reportSuffix is appended to the file name for its sidecar report
+	reportSuffix = ".report.json"
+)
+
+// WatchOptions configures the directory watch mode
+type WatchOptions struct {
+	// Dir is the inbox directory where csv files are dropped
+	Dir string
+	// PollInterval is the time between two scans of inbox directory
+	PollInterval time.Duration
+	// Ingest configures how the picked csv files are read
+	Ingest Options
+}
+
+// WatchReport is written as sidecar json file next to every processed or failed csv file
+type WatchReport struct {
+	File        string    `json:"file"`
+	Status      string    `json:"status"`
+	BatchID     string    `json:"batch_id,omitempty"`
+	Published   int       `json:"published"`
+	Confirmed   int       `json:"confirmed"`
+	Failed      int       `json:"failed"`
+	Rejected    int       `json:"rejected"`
+	RejectsFile string    `json:"rejects_file,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+}
+
+// fileState is used to detect that a file is completely written before picking it
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// Watch will monitor the inbox directory and ingest every csv file landing in it until the context is cancelled
+// A file is picked once its size and modification time are unchanged between two scans
+func Watch(ctx context.Context, logger *zap.Logger, producer *pipeline.Producer, opts WatchOptions) error {
+	for _, dir := range []string{ProcessedDir, FailedDir} {
+		err := os.MkdirAll(filepath.Join(opts.Dir, dir), 0o755)
+		if err != nil {
+			logger.Error("failed to create watch directory:" + err.Error())
+			return err
+		}
+	}
+
+	logger.Info("watching inbox directory for csv files", zap.String("dir", opts.Dir), zap.Duration("poll_interval", opts.PollInterval))
+
+	ticker := time.NewTicker(opts.PollInterval)
+	defer ticker.Stop()
+
+	pending := make(map[string]fileState)
+	// stuck keeps the ingested files which could not be moved out of inbox, so they are not ingested again
+	// until they are changed
+	stuck := make(map[string]fileState)
+
+	for {
+		files, err := filepath.Glob(filepath.Join(opts.Dir, "*.csv"))
+		if err != nil {
+			logger.Error("failed to scan inbox directory:" + err.Error())
+			return err
+		}
+		files = slices.DeleteFunc(files, isRejectsFile)
+		sort.Strings(files)
+
+		seen := make(map[string]bool, len(files))
+		for _, file := range files {
+			seen[file] = true
+
+			info, err := os.Stat(file)
+			if err != nil {
+				// File can be removed between scan and stat
+				continue
+			}
+
+			state := fileState{size: info.Size(), modTime: info.ModTime()}
+			if stuckState, ok := stuck[file]; ok {
+				if stuckState == state {
+					continue
+				}
+				delete(stuck, file)
+			}
+
+			previous, ok := pending[file]
+			pending[file] = state
+			if !ok || previous != state {
+				// File is new or still being written, check again in next scan
+				continue
+			}
+
+			delete(pending, file)
+			if !processFile(ctx, logger, producer, opts, file) {
+				stuck[file] = state
+			}
+
+			if ctx.Err() != nil {
+				return nil
+			}
+		}
+
+		// Forget the files which are removed from inbox
+		for file := range pending {
+			if !seen[file] {
+				delete(pending, file)
+			}
+		}
+		for file := range stuck {
+			if !seen[file] {
+				delete(stuck, file)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// processFile will ingest the file and move it with its report to processed or failed directory
+// it reports whether the file is moved out of inbox, a file stopped by cancelled context is kept in inbox with its checkpoint
+func processFile(ctx context.Context, logger *zap.Logger, producer *pipeline.Producer, opts WatchOptions, file string) bool {
+	report := WatchReport{
+		File:      filepath.Base(file),
+		Status:    ProcessedDir,
+		StartedAt: time.Now().UTC(),
+	}
+
+	logger.Info("ingesting csv file", zap.String("file", file))
+
+	stats, err := IngestCSV(ctx, logger, producer, file, opts.Ingest)
+	if err != nil && ctx.Err() != nil {
+		logger.Info("csv file ingestion is stopped, it is resumed from checkpoint on next start", zap.String("file", file), zap.Int("confirmed", stats.Confirmed))
+		return false
+	}
+
+	report.BatchID = stats.BatchID
+	report.Published = stats.Published
+	report.Confirmed = stats.Confirmed
+	report.Failed = stats.Failed
+	report.Rejected = stats.Rejected
+	report.FinishedAt = time.Now().UTC()
+	if err != nil {
+		report.Status = FailedDir
+		report.Error = err.Error()
+		logger.Error("failed to ingest csv file", zap.String("file", file), zap.Int("published", stats.Published), zap.Int("confirmed", stats.Confirmed), zap.Int("failed", stats.Failed), zap.Int("rejected", stats.Rejected), zap.Error(err))
+	} else {
+		logger.Info("csv file ingested", zap.String("file", file), zap.String("batch_id", stats.BatchID), zap.Int("published", stats.Published), zap.Int("confirmed", stats.Confirmed), zap.Int("failed", stats.Failed), zap.Int("rejected", stats.Rejected))
+	}
+
+	destination := filepath.Join(opts.Dir, report.Status, report.File)
+	if _, err := os.Stat(destination); err == nil {
+		// Do not overwrite the file with same name processed earlier
+		destination = filepath.Join(opts.Dir, report.Status, strconv.FormatInt(report.StartedAt.UnixMilli(), 10)+"_"+report.File)
+	}
+
+	err = os.Rename(file, destination)
+	if err != nil {
+		logger.Error("failed to move csv file, it is not ingested again until it is changed:" + err.Error())
+		return false
+	}
+
+	// Checkpoint is only needed while the file is in inbox
+	if opts.Ingest.Checkpoints != nil {
+		opts.Ingest.Checkpoints.Delete(file)
+		err = opts.Ingest.Checkpoints.Save()
+		if err != nil {
+			logger.Error("failed to save csv checkpoints:" + err.Error())
+		}
+	}
+
+	// Keep the rejects file next to the csv file, so it is not picked as new file from inbox
+	if stats.RejectsFile != "" {
+		report.RejectsFile = strings.TrimSuffix(destination, filepath.Ext(destination)) + rejectsSuffix
+		err = os.Rename(stats.RejectsFile, report.RejectsFile)
+		if err != nil {
+			logger.Error("failed to move rejects csv file:" + err.Error())
+			report.RejectsFile = stats.RejectsFile
+		}
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		logger.Error("failed to marshal csv file report:" + err.Error())
+		return true
+	}
+
+	err = os.WriteFile(destination+reportSuffix, data, 0o644)
+	if err != nil {
+		logger.Error("failed to write csv file report:" + err.Error())
+	}
+
+	return true
+}
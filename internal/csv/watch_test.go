@@ -0,0 +1,207 @@
+package csv_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vatsal3003/viswals/internal/broker"
+	"github.com/vatsal3003/viswals/internal/broker/memory"
+	"github.com/vatsal3003/viswals/internal/csv"
+	"github.com/vatsal3003/viswals/internal/pipeline"
+	"go.uber.org/zap"
+)
+
+const usersHeader = "id,first_name,last_name,email_address,created_at,deleted_at,merged_at,parent_user_id\n"
+
+// startWatch will watch the inbox until the test ends
+func startWatch(t *testing.T, inbox string, messageBroker *memory.Broker) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- csv.Watch(ctx, zap.NewNop(), pipeline.NewProducer(messageBroker, pipeline.ProducerOptions{}), csv.WatchOptions{
+			Dir:          inbox,
+			PollInterval: 10 * time.Millisecond,
+		})
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+	})
+}
+
+// readReport will read the sidecar report of the moved csv file
+func readReport(t *testing.T, file string) csv.WatchReport {
+	var report csv.WatchReport
+	data, err := os.ReadFile(file + ".report.json")
+	if assert.NoError(t, err) {
+		assert.NoError(t, json.Unmarshal(data, &report))
+	}
+	return report
+}
+
+func TestWatch(t *testing.T) {
+	inbox := t.TempDir()
+	messageBroker := memory.New()
+	defer messageBroker.Close()
+
+	startWatch(t, inbox, messageBroker)
+
+	err := os.WriteFile(filepath.Join(inbox, "users.csv"), []byte(usersHeader+
+		"42,Grace,Taylor,GraceTaylor1951@inbox.edu,1361459822000,-1,-1,-1\n"+
+		"0,Invalid,User,invalid@gmail.edu,1361367320000,-1,-1,-1\n"), 0o644)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(inbox, "unknown.csv"), []byte("foo,bar\n1,2\n"), 0o644)
+	assert.NoError(t, err)
+
+	processed := filepath.Join(inbox, csv.ProcessedDir, "users.csv")
+	failed := filepath.Join(inbox, csv.FailedDir, "unknown.csv")
+	assert.Eventually(t, func() bool {
+		_, processedErr := os.Stat(processed + ".report.json")
+		_, failedErr := os.Stat(failed + ".report.json")
+		return processedErr == nil && failedErr == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	files, err := filepath.Glob(filepath.Join(inbox, "*.csv"))
+	assert.NoError(t, err)
+	assert.Empty(t, files, "ingested files should be moved out of inbox")
+
+	report := readReport(t, processed)
+	assert.Equal(t, "users.csv", report.File)
+	assert.Equal(t, csv.ProcessedDir, report.Status)
+	assert.Equal(t, 1, report.Published)
+	assert.Equal(t, 1, report.Confirmed)
+	assert.Equal(t, 1, report.Rejected)
+	assert.Equal(t, filepath.Join(inbox, csv.ProcessedDir, "users.rejects.csv"), report.RejectsFile)
+	assert.FileExists(t, report.RejectsFile)
+	assert.Empty(t, report.Error)
+
+	report = readReport(t, failed)
+	assert.Equal(t, csv.FailedDir, report.Status)
+	assert.NotEmpty(t, report.Error)
+
+	depth, err := messageBroker.QueueDepth()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, depth)
+}
+
+func TestWatchFileNotMoved(t *testing.T) {
+	inbox := t.TempDir()
+	messageBroker := memory.New()
+	defer messageBroker.Close()
+
+	startWatch(t, inbox, messageBroker)
+
+	// Replace the processed directory by a file, so ingested files can not be moved into it
+	processed := filepath.Join(inbox, csv.ProcessedDir)
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(processed)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(t, os.Remove(processed))
+	assert.NoError(t, os.WriteFile(processed, nil, 0o644))
+
+	file := filepath.Join(inbox, "users.csv")
+	rows := usersHeader + "42,Grace,Taylor,GraceTaylor1951@inbox.edu,1361459822000,-1,-1,-1\n"
+	assert.NoError(t, os.WriteFile(file, []byte(rows), 0o644))
+
+	queued := func(want int) func() bool {
+		return func() bool {
+			depth, _ := messageBroker.QueueDepth()
+			return depth == want
+		}
+	}
+
+	assert.Eventually(t, queued(1), 5*time.Second, 10*time.Millisecond)
+	assert.Never(t, queued(2), 200*time.Millisecond, 10*time.Millisecond, "file which is not moved should not be ingested again")
+	assert.FileExists(t, file)
+
+	// Changed file is picked again
+	rows += "31,Emily,Tamm,EmilyTamm@gmail.edu,1361367320000,-1,-1,-1\n"
+	assert.NoError(t, os.WriteFile(file, []byte(rows), 0o644))
+	assert.Eventually(t, queued(3), 5*time.Second, 10*time.Millisecond)
+}
+
+// cancellingPublisher cancels the context once it has published the messages, like a shutdown in the middle of a file
+type cancellingPublisher struct {
+	*memory.Broker
+	cancel    context.CancelFunc
+	messages  int
+	published int
+}
+
+func (p *cancellingPublisher) Publish(ctx context.Context, message broker.Message) (broker.Confirmation, error) {
+	confirmation, err := p.Broker.Publish(ctx, message)
+	p.published++
+	if p.published == p.messages {
+		p.cancel()
+	}
+	return confirmation, err
+}
+
+func TestWatchStopped(t *testing.T) {
+	inbox := t.TempDir()
+	messageBroker := memory.New()
+	defer messageBroker.Close()
+
+	checkpoints, err := csv.LoadCheckpoints(filepath.Join(t.TempDir(), "checkpoints.json"))
+	assert.NoError(t, err)
+	opts := csv.WatchOptions{
+		Dir:          inbox,
+		PollInterval: 10 * time.Millisecond,
+		Ingest:       csv.Options{Checkpoints: checkpoints},
+	}
+
+	file := filepath.Join(inbox, "users.csv")
+	err = os.WriteFile(file, []byte(usersHeader+
+		"42,Grace,Taylor,GraceTaylor1951@inbox.edu,1361459822000,-1,-1,-1\n"+
+		"31,Emily,Tamm,EmilyTamm@gmail.edu,1361367320000,-1,-1,-1\n"+
+		"8,Hanah,Schmidt,Hanah_Schmidt1965@gmail.edu,1361218223000,-1,-1,-1\n"+
+		"13,Noah,Brown,NoahBrown@inbox.edu,1361218223000,-1,-1,-1\n"), 0o644)
+	assert.NoError(t, err)
+
+	// Shutdown stops the ingestion after two rows
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	publisher := &cancellingPublisher{Broker: messageBroker, cancel: cancel, messages: 2}
+	assert.NoError(t, csv.Watch(ctx, zap.NewNop(), pipeline.NewProducer(publisher, pipeline.ProducerOptions{}), opts))
+
+	assert.FileExists(t, file, "stopped file should be kept in inbox")
+	assert.NoFileExists(t, filepath.Join(inbox, csv.FailedDir, "users.csv"))
+
+	checkpoint, ok := checkpoints.Get(file)
+	if assert.True(t, ok, "checkpoint of stopped file should be kept") {
+		assert.Equal(t, 2, checkpoint.Published)
+		assert.Equal(t, 3, checkpoint.Line)
+		assert.False(t, checkpoint.Completed)
+	}
+
+	// Next start resumes the file after the confirmed rows
+	ctx, cancel = context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- csv.Watch(ctx, zap.NewNop(), pipeline.NewProducer(messageBroker, pipeline.ProducerOptions{}), opts)
+	}()
+
+	processed := filepath.Join(inbox, csv.ProcessedDir, "users.csv")
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(processed + ".report.json")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	assert.NoError(t, <-done)
+
+	assert.Equal(t, 2, readReport(t, processed).Confirmed)
+
+	depth, err := messageBroker.QueueDepth()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, depth)
+
+	_, ok = checkpoints.Get(file)
+	assert.False(t, ok, "checkpoint should be removed once the file is processed")
+}
@@ -3,26 +3,83 @@ package database
 import (
 	"context"
 	"database/sql"
+	_ "embed"
+	"errors"
 	"log"
+	"net/url"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 	_ "github.com/lib/pq"
 	"github.com/redis/go-redis/v9"
+	"github.com/vatsal3003/viswals/internal/cache"
 	"github.com/vatsal3003/viswals/internal/consts"
+	"github.com/vatsal3003/viswals/migrations"
 	"go.uber.org/zap"
+	_ "modernc.org/sqlite"
 )
 
-// Database struct contains postgresql and redis connection
+// sqliteSchema creates the tables of lite mode, it is applied every time as its statements are idempotent
+//
+//go:embed sqlite.sql
+var sqliteSchema string
+
+const (
+	// minRedisRetryDelay and maxRedisRetryDelay bound the backoff between pings of redis which is not reachable at start
+	minRedisRetryDelay = time.Second
+	maxRedisRetryDelay = 30 * time.Second
+)
+
+// sqliteVersion is the user_version of sqlite schema, it is increased when a change of schema needs to rebuild a table
+// version 1 adds the constraints and parent foreign key of users
+const sqliteVersion = 1
+
+const (
+	// sqliteRenameUsers keeps the users of a file created before version 1 aside, the indexes are dropped as their names are reused
+	sqliteRenameUsers = `DROP INDEX IF EXISTS users_parent_user_id_idx;
+DROP INDEX IF EXISTS users_created_at_id_idx;
+DROP INDEX IF EXISTS users_last_name_id_idx;
+ALTER TABLE users RENAME TO users_v0;`
+
+	// sqliteCopyUsers moves the kept users into the users table of current schema
+	sqliteCopyUsers = `INSERT INTO users (id, first_name, last_name, email_address, created_at, deleted_at, merged_at, parent_user_id)
+SELECT id, first_name, last_name, email_address, created_at, deleted_at, merged_at, parent_user_id FROM users_v0;
+DROP TABLE users_v0;`
+)
+
+// Database struct contains postgresql and redis connection, or sqlite connection in lite mode
+// RedisDB is nil when redis is not available
 type Database struct {
-	PgDB    *sql.DB
-	RedisDB *redis.Client
+	PgDB     *sql.DB
+	RedisDB  *redis.Client
+	SQLiteDB *sql.DB
+
+	// unreachableRedis is the configured redis which is not reachable at start, it is connected in background by the cache
+	unreachableRedis *redis.Client
 }
 
 // New will initialize postgres and redis database connection and put them into struct and return it
 func New(logger *zap.Logger) (*Database, error) {
+	db, err := NewPostgres(logger)
+	if err != nil {
+		return nil, err
+	}
+
+	db.RedisDB, db.unreachableRedis, err = newRedis(logger)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// NewPostgres will initialize only postgres database connection, e.g. to run migrations
+func NewPostgres(logger *zap.Logger) (*Database, error) {
 	// Initialize postgresql database connection
 	pgDB, err := sql.Open("postgres", os.Getenv(consts.PostgresConnURL))
 	if err != nil {
@@ -41,57 +98,336 @@ func New(logger *zap.Logger) (*Database, error) {
 		return nil, err
 	}
 
+	return &Database{
+		PgDB: pgDB,
+	}, nil
+}
+
+// newRedis will connect redis, redis is not critical as users can be read from postgres,
+// so nil client is returned when it is not configured and the in-process cache is used instead
+// the client of redis which is not reachable is returned as unreachable, so it can be connected later
+func newRedis(logger *zap.Logger) (client, unreachable *redis.Client, err error) {
+	connURL := os.Getenv(consts.RedisConnURL)
+	if connURL == "" {
+		logger.Warn("redis connection url is not set, using in-process cache")
+		return nil, nil, nil
+	}
+
 	// Fetch the connection options by parsing the redis connection url
-	redisConnOptions, err := redis.ParseURL(os.Getenv(consts.RedisConnURL))
+	redisConnOptions, err := redis.ParseURL(connURL)
 	if err != nil {
 		logger.Error("failed to parse redis connection url:" + err.Error())
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Initialize the redis database connection
 	redisDB := redis.NewClient(redisConnOptions)
 
 	// Ping redis database to test the connection
-	status := redisDB.Ping(context.Background())
-	if status.Err() != nil {
-		logger.Error("failed to ping redis database connection:" + status.Err().Error())
+	err = redisDB.Ping(context.Background()).Err()
+	if err != nil {
+		logger.Warn("failed to ping redis database connection, using in-process cache until it is reachable:" + err.Error())
+		return nil, redisDB, nil
+	}
+
+	return redisDB, nil, nil
+}
+
+// connectRedis will ping redis with backoff until it is reachable and then switch the cache to redis,
+// it stops when the client is closed
+func connectRedis(logger *zap.Logger, client *redis.Client, userCache *cache.Switching, ttl time.Duration) {
+	delay := minRedisRetryDelay
+	for {
+		time.Sleep(delay)
+
+		err := client.Ping(context.Background()).Err()
+		if errors.Is(err, redis.ErrClosed) {
+			return
+		}
+		if err == nil {
+			break
+		}
+
+		delay = min(2*delay, maxRedisRetryDelay)
+		logger.Warn("failed to ping redis database connection, using in-process cache:"+err.Error(), zap.Duration("retry_in", delay))
+	}
+
+	userCache.Switch(cache.NewRedis(client, ttl))
+	logger.Info("redis database connection is reachable, switched from in-process cache to redis")
+}
+
+// NewLite will open the sqlite database file at path and create its tables, lite mode runs without postgres and redis
+func NewLite(logger *zap.Logger, path string) (*Database, error) {
+	// Timestamps are stored as RFC 3339 text in UTC, so they are ordered as text as well
+	// path is escaped, as sqlite reads the file name as uri
+	sqliteDB, err := sql.Open("sqlite", "file:"+(&url.URL{Path: path}).EscapedPath()+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)&_time_format=sqlite")
+	if err != nil {
+		logger.Error("failed to open sqlite database:" + err.Error())
+		return nil, err
+	}
+
+	// Sqlite allows one writer at a time
+	sqliteDB.SetMaxOpenConns(1)
+
+	err = migrateLite(sqliteDB)
+	if err != nil {
+		sqliteDB.Close()
+		logger.Error("failed to create sqlite tables, a database file which can not be upgraded has to be recreated:" + err.Error())
 		return nil, err
 	}
 
 	return &Database{
-		PgDB:    pgDB,
-		RedisDB: redisDB,
+		SQLiteDB: sqliteDB,
 	}, nil
 }
 
-// Migrate will run migration scripts
-func (db *Database) Migrate(logger *zap.Logger) error {
-	logger.Info("database migration initialized")
+// migrateLite will create the tables of sqlite database and upgrade the file of older schema version,
+// as sqlite can not add constraints to an existing table, the users table of older file is rebuilt with its users
+func migrateLite(db *sql.DB) error {
+	var version int
+	err := db.QueryRow("PRAGMA user_version;").Scan(&version)
+	if err != nil {
+		return err
+	}
+	if version > sqliteVersion {
+		return errors.New("sqlite database file has newer schema version " + strconv.Itoa(version))
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Files created before the schema is versioned have version 0
+	var rebuild bool
+	if version < sqliteVersion {
+		err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'users');").Scan(&rebuild)
+		if err != nil {
+			return err
+		}
+	}
+
+	if rebuild {
+		_, err = tx.Exec(sqliteRenameUsers)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(sqliteSchema)
+	if err != nil {
+		return err
+	}
+
+	if rebuild {
+		_, err = tx.Exec(sqliteCopyUsers)
+		if err != nil {
+			return errors.New("failed to copy users into new users table:" + err.Error())
+		}
+
+		// Foreign key is checked before commit, as sqlite keeps the transaction open when commit fails on it
+		var orphans int
+		err = tx.QueryRow("SELECT COUNT(*) FROM pragma_foreign_key_check('users');").Scan(&orphans)
+		if err != nil {
+			return err
+		}
+		if orphans != 0 {
+			return errors.New(strconv.Itoa(orphans) + " users have parent_user_id of missing user")
+		}
+	}
+
+	_, err = tx.Exec("PRAGMA user_version = " + strconv.Itoa(sqliteVersion) + ";")
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// NewCache will return the cache over redis, or the in-process cache of size users when redis is not available
+// when redis is configured but not reachable at start, the in-process cache is switched to redis once it is reachable
+func (db *Database) NewCache(logger *zap.Logger, ttl time.Duration, size int) cache.Cache {
+	if db.RedisDB != nil {
+		return cache.NewRedis(db.RedisDB, ttl)
+	}
+	if db.unreachableRedis != nil {
+		userCache := cache.NewSwitching(cache.NewLRU(size, ttl))
+		go connectRedis(logger, db.unreachableRedis, userCache, ttl)
+		return userCache
+	}
+	return cache.NewLRU(size, ttl)
+}
+
+// migrator will return the migrate instance of the embedded migration scripts over postgres
+func (db *Database) migrator(logger *zap.Logger) (*migrate.Migrate, error) {
+	source, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		logger.Error("failed to read embedded migrations:" + err.Error())
+		return nil, err
+	}
 
 	dbDriver, err := postgres.WithInstance(db.PgDB, &postgres.Config{})
 	if err != nil {
 		logger.Error("failed to get database driver for migration:" + err.Error())
-		return err
+		return nil, err
 	}
 
-	m, err := migrate.NewWithDatabaseInstance("file://migrations", "postgres", dbDriver)
+	m, err := migrate.NewWithInstance("iofs", source, "postgres", dbDriver)
 	if err != nil {
 		logger.Error("failed to create new migrate instance:" + err.Error())
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// checkDirty will fail when the last migration is failed halfway, as the schema has to be fixed by hand
+// and the version has to be forced before any other migration is applied
+func checkDirty(logger *zap.Logger, m *migrate.Migrate) error {
+	version, dirty, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		logger.Error("failed to get database migration version:" + err.Error())
 		return err
 	}
 
-	err = m.Down()
-	if err != nil && err != migrate.ErrNoChange {
-		logger.Error("failed to apply down migrations:" + err.Error())
+	if dirty {
+		err = errors.New("database is dirty at migration version " + strconv.FormatUint(uint64(version), 10) + ", fix the schema and force the version")
+		logger.Error(err.Error())
 		return err
 	}
-	err = m.Up()
-	if err != nil && err != migrate.ErrNoChange {
+
+	return nil
+}
+
+// Migrate will apply the pending migrations, n limits the number of applied migrations and 0 applies all of them
+// applied migrations are never reverted, so the existing data is kept
+func (db *Database) Migrate(logger *zap.Logger, n int) error {
+	logger.Info("database migration initialized")
+
+	m, err := db.migrator(logger)
+	if err != nil {
+		return err
+	}
+
+	err = checkDirty(logger, m)
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		err = m.Steps(n)
+	} else {
+		err = m.Up()
+	}
+	if errors.Is(err, migrate.ErrNoChange) {
+		logger.Info("database schema is up to date")
+		db.warnInvalidConstraints(logger)
+		return nil
+	}
+	if err != nil {
 		logger.Error("failed to apply up migrations:" + err.Error())
 		return err
 	}
 
-	logger.Info("database migration completed successfully")
+	version, _, _ := m.Version()
+	logger.Info("database migration completed successfully", zap.Uint("version", version))
+	db.warnInvalidConstraints(logger)
+
+	return nil
+}
+
+// warnInvalidConstraints will report the constraints of users which are left not valid by migrations,
+// as existing rows violate them, they are checked only for new and updated rows until the rows are fixed
+func (db *Database) warnInvalidConstraints(logger *zap.Logger) {
+	rows, err := db.PgDB.Query("SELECT conname FROM pg_constraint WHERE conrelid = to_regclass('users') AND NOT convalidated ORDER BY conname;")
+	if err != nil {
+		logger.Warn("failed to check constraints of users:" + err.Error())
+		return
+	}
+	defer rows.Close()
+
+	var constraints []string
+	for rows.Next() {
+		var constraint string
+		err = rows.Scan(&constraint)
+		if err != nil {
+			logger.Warn("failed to check constraints of users:" + err.Error())
+			return
+		}
+		constraints = append(constraints, constraint)
+	}
+
+	if len(constraints) != 0 {
+		logger.Warn("existing users violate constraints, fix the users and validate the constraints", zap.Strings("constraints", constraints))
+	}
+}
+
+// MigrateDown will revert the last n migrations
+func (db *Database) MigrateDown(logger *zap.Logger, n int) error {
+	if n <= 0 {
+		return errors.New("number of migrations to revert must be positive")
+	}
+
+	m, err := db.migrator(logger)
+	if err != nil {
+		return err
+	}
+
+	err = checkDirty(logger, m)
+	if err != nil {
+		return err
+	}
+
+	err = m.Steps(-n)
+	if err != nil {
+		logger.Error("failed to apply down migrations:" + err.Error())
+		return err
+	}
+
+	version, _, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		logger.Info("every database migration is reverted")
+		return nil
+	}
+	logger.Info("database migrations reverted", zap.Uint("version", version))
+
+	return nil
+}
+
+// MigrationVersion will return the applied migration version and whether it is dirty, version is 0 when no migration is applied
+func (db *Database) MigrationVersion(logger *zap.Logger) (uint, bool, error) {
+	m, err := db.migrator(logger)
+	if err != nil {
+		return 0, false, err
+	}
+
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+	if err != nil {
+		logger.Error("failed to get database migration version:" + err.Error())
+		return 0, false, err
+	}
+
+	return version, dirty, nil
+}
+
+// ForceMigration will set the migration version without running migrations and clear the dirty state
+func (db *Database) ForceMigration(logger *zap.Logger, version int) error {
+	m, err := db.migrator(logger)
+	if err != nil {
+		return err
+	}
+
+	err = m.Force(version)
+	if err != nil {
+		logger.Error("failed to force database migration version:" + err.Error())
+		return err
+	}
+
+	logger.Info("database migration version forced", zap.Int("version", version))
 
 	return nil
 }
@@ -104,11 +440,20 @@ func (db *Database) Close() {
 		}
 	}
 
-	if db.RedisDB != nil {
-		err := db.RedisDB.Close()
+	if db.SQLiteDB != nil {
+		err := db.SQLiteDB.Close()
 		if err != nil {
-			log.Println("ERROR failed to close redis database connection:" + err.Error())
-			return
+			log.Println("ERROR failed to close sqlite database connection:" + err.Error())
+		}
+	}
+
+	for _, redisDB := range []*redis.Client{db.RedisDB, db.unreachableRedis} {
+		if redisDB != nil {
+			err := redisDB.Close()
+			if err != nil {
+				log.Println("ERROR failed to close redis database connection:" + err.Error())
+				return
+			}
 		}
 	}
 }
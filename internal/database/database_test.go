@@ -0,0 +1,106 @@
+package database_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vatsal3003/viswals/internal/database"
+	"go.uber.org/zap"
+)
+
+// unversionedSchema is the users table of the files created before the sqlite schema is versioned
+const unversionedSchema = `CREATE TABLE users (
+    id INTEGER PRIMARY KEY,
+    first_name TEXT,
+    last_name TEXT,
+    email_address TEXT,
+    created_at TIMESTAMP,
+    deleted_at TIMESTAMP,
+    merged_at TIMESTAMP,
+    parent_user_id INTEGER
+);`
+
+// createUnversioned will create a database file of unversioned schema with the users
+func createUnversioned(t *testing.T, users string) string {
+	path := filepath.Join(t.TempDir(), "users.db")
+
+	db, err := sql.Open("sqlite", path)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer db.Close()
+
+	_, err = db.Exec(unversionedSchema + "INSERT INTO users VALUES " + users + ";")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	return path
+}
+
+func userVersion(t *testing.T, db *sql.DB) int {
+	var version int
+	assert.NoError(t, db.QueryRow("PRAGMA user_version;").Scan(&version))
+	return version
+}
+
+func TestNewLite(t *testing.T) {
+	t.Run("New file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "users.db")
+
+		// Opening the file again keeps it as it is
+		for range 2 {
+			db, err := database.NewLite(zap.NewNop(), path)
+			if assert.NoError(t, err) {
+				assert.Equal(t, 1, userVersion(t, db.SQLiteDB))
+				db.Close()
+			}
+		}
+	})
+
+	t.Run("Unversioned file is upgraded", func(t *testing.T) {
+		path := createUnversioned(t, `(8, 'Hanah', 'Schmidt', 'hanah@gmail.edu', '2013-02-18 20:10:23+00:00', NULL, NULL, NULL),
+			(13, 'Grace', 'Taylor', 'grace@inbox.edu', '2013-02-21 15:17:02+00:00', NULL, '2013-02-22 15:17:02+00:00', 8)`)
+
+		db, err := database.NewLite(zap.NewNop(), path)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		defer db.Close()
+
+		assert.Equal(t, 1, userVersion(t, db.SQLiteDB))
+
+		var parentUserID int
+		assert.NoError(t, db.SQLiteDB.QueryRow("SELECT parent_user_id FROM users WHERE id = 13;").Scan(&parentUserID))
+		assert.Equal(t, 8, parentUserID)
+
+		var indexes int
+		assert.NoError(t, db.SQLiteDB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND tbl_name = 'users';").Scan(&indexes))
+		assert.Equal(t, 3, indexes)
+
+		// Constraints of users are enforced after the upgrade
+		_, err = db.SQLiteDB.Exec("INSERT INTO users VALUES (0, 'Invalid', 'User', 'invalid@gmail.edu', '2013-02-18 20:10:23+00:00', NULL, NULL, NULL);")
+		assert.Error(t, err)
+	})
+
+	t.Run("Unversioned file with invalid users is kept", func(t *testing.T) {
+		path := createUnversioned(t, `(13, 'Grace', 'Taylor', 'grace@inbox.edu', '2013-02-21 15:17:02+00:00', NULL, NULL, 404)`)
+
+		_, err := database.NewLite(zap.NewNop(), path)
+		assert.ErrorContains(t, err, "parent_user_id of missing user")
+
+		db, err := sql.Open("sqlite", path)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		defer db.Close()
+
+		assert.Equal(t, 0, userVersion(t, db))
+
+		var users int
+		assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM users;").Scan(&users))
+		assert.Equal(t, 1, users)
+	})
+}
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY CHECK (id > 0),
+    first_name TEXT NOT NULL,
+    last_name TEXT NOT NULL,
+    email_address TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMP CHECK (deleted_at >= created_at),
+    merged_at TIMESTAMP CHECK (merged_at >= created_at),
+    parent_user_id INTEGER CHECK (parent_user_id <> id) REFERENCES users (id) DEFERRABLE INITIALLY DEFERRED,
+    CHECK (merged_at IS NULL OR parent_user_id IS NOT NULL)
+);
+
+CREATE INDEX IF NOT EXISTS users_parent_user_id_idx ON users (parent_user_id);
+CREATE INDEX IF NOT EXISTS users_created_at_id_idx ON users (created_at, id);
+CREATE INDEX IF NOT EXISTS users_last_name_id_idx ON users (last_name, id);
+
+CREATE TABLE IF NOT EXISTS user_lineage (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    schema_version INTEGER NOT NULL,
+    source TEXT,
+    line INTEGER,
+    batch_id TEXT,
+    published_at TIMESTAMP,
+    consumed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS user_lineage_user_id_idx ON user_lineage (user_id);
+CREATE INDEX IF NOT EXISTS user_lineage_batch_id_idx ON user_lineage (batch_id);
@@ -0,0 +1,207 @@
+package pipeline
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/vatsal3003/viswals/internal/broker"
+	"github.com/vatsal3003/viswals/internal/service/userservice"
+	"github.com/vatsal3003/viswals/models"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultBatchSize is the number of users written to database at once
+	defaultBatchSize = 500
+	// defaultFlushInterval is the longest time a decoded user waits in batch before it is written
+	defaultFlushInterval = 500 * time.Millisecond
+)
+
+// decodedMessage is the delivery with the user decoded from it, waiting in batch to be stored
+type decodedMessage struct {
+	delivery broker.Delivery
+	user     *models.User
+	// cached is the gob encoded user which is set in cache
+	cached  []byte
+	lineage models.UserLineage
+}
+
+func (opts *ConsumerOptions) batchSize() int {
+	if opts.BatchSize <= 0 {
+		return defaultBatchSize
+	}
+	return opts.BatchSize
+}
+
+func (opts *ConsumerOptions) flushInterval() time.Duration {
+	if opts.FlushInterval <= 0 {
+		return defaultFlushInterval
+	}
+	return opts.FlushInterval
+}
+
+// prefetch is the number of unacknowledged messages broker delivers at once,
+// it covers the messages waiting in batch together with the messages being decoded by workers
+func (opts *ConsumerOptions) prefetch() int {
+	return opts.workers()*prefetchPerWorker + opts.batchSize()
+}
+
+// batch will collect the decoded messages and store them when the batch is full or flush interval is passed
+// it returns once the decoded messages are closed
+func (c *Consumer) batch(logger *zap.Logger, decoded <-chan decodedMessage) {
+	size, interval := c.opts.batchSize(), c.opts.flushInterval()
+
+	pending := make([]decodedMessage, 0, size)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message, ok := <-decoded:
+			if !ok {
+				if len(pending) != 0 {
+					c.flush(logger, pending)
+				}
+				return
+			}
+
+			pending = append(pending, message)
+			c.batched.Add(1)
+			if len(pending) < size {
+				continue
+			}
+		case <-ticker.C:
+			if len(pending) == 0 {
+				continue
+			}
+		}
+
+		c.flush(logger, pending)
+		pending = pending[:0]
+		ticker.Reset(interval)
+	}
+}
+
+// flush will store the batch in one transaction and acknowledge its messages after commit
+// when the batch can not be stored, its users are stored one by one, so only the failing messages are retried
+func (c *Consumer) flush(logger *zap.Logger, pending []decodedMessage) {
+	defer c.batched.Add(-int64(len(pending)))
+
+	start := time.Now()
+
+	users := make([]*models.User, len(pending))
+	lineages := make([]models.UserLineage, len(pending))
+	for i := range pending {
+		users[i] = pending[i].user
+		lineages[i] = pending[i].lineage
+	}
+
+	written, err := c.store.StoreUsers(users, lineages)
+	if err != nil {
+		logger.Error("failed to insert batch of users into database, inserting them one by one:" + err.Error())
+		c.flushEach(logger, pending)
+		return
+	}
+
+	// Refresh the cache of written users and invalidate the kept ones, so cache matches the database
+	// users repeated in batch are invalidated as well, as the conflict policy decides which one is written
+	cached := make(map[int][]byte, len(pending))
+	repeated := make(map[int]bool)
+	for _, message := range pending {
+		if _, ok := cached[message.user.ID]; ok {
+			repeated[message.user.ID] = true
+		}
+		cached[message.user.ID] = message.cached
+	}
+
+	refreshed := make(map[int][]byte, len(written))
+	for _, id := range written {
+		if !repeated[id] {
+			refreshed[id] = cached[id]
+			delete(cached, id)
+		}
+	}
+
+	var kept []int
+	for id := range cached {
+		kept = append(kept, id)
+	}
+
+	c.store.CacheUsers(refreshed, kept)
+
+	for _, message := range pending {
+		err = message.delivery.Ack()
+		if err != nil {
+			logger.Error("failed to acknowledge message:" + err.Error())
+		}
+	}
+
+	took := time.Since(start)
+	logger.Info("stored batch of users",
+		zap.Int("size", len(pending)),
+		zap.Int("written", len(written)),
+		zap.Duration("took", took),
+		zap.Float64("users_per_second", float64(len(pending))/took.Seconds()),
+	)
+}
+
+// flushEach will store the users of failed batch one by one
+// users whose parent is not stored yet are stored again after the other users of batch, as their parent may be
+// among them, the ones still missing their parent are retried later as the parent may be in a later batch
+func (c *Consumer) flushEach(logger *zap.Logger, pending []decodedMessage) {
+	for len(pending) != 0 {
+		var orphans []decodedMessage
+
+		for _, message := range pending {
+			err := c.storeEach(logger, message)
+			if errors.Is(err, userservice.ErrMissingParent) {
+				orphans = append(orphans, message)
+				continue
+			}
+			if err != nil {
+				logger.Error("failed to process message:" + err.Error())
+				c.fail(logger, message.delivery, err)
+			}
+		}
+
+		if len(orphans) == len(pending) {
+			for _, message := range orphans {
+				err := errors.New("failed to insert user into database, parent user is not stored yet:" + strconv.Itoa(message.user.ID))
+				logger.Error("failed to process message:" + err.Error())
+				c.fail(logger, message.delivery, err)
+			}
+			return
+		}
+		pending = orphans
+	}
+}
+
+// storeEach will store the user of message and acknowledge it, the failed message is left to the caller
+func (c *Consumer) storeEach(logger *zap.Logger, message decodedMessage) error {
+	written, err := c.store.StoreUser(message.user, message.lineage)
+	if errors.Is(err, userservice.ErrMissingParent) {
+		return err
+	}
+	if err != nil {
+		reason := errors.New("failed to insert user into database:" + err.Error())
+		if isPermanent(err) {
+			reason = permanent(reason)
+		}
+		return reason
+	}
+
+	if written {
+		c.store.CacheUsers(map[int][]byte{message.user.ID: message.cached}, nil)
+	} else {
+		c.store.CacheUsers(nil, []int{message.user.ID})
+	}
+
+	err = message.delivery.Ack()
+	if err != nil {
+		logger.Error("failed to acknowledge message:" + err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vatsal3003/viswals/internal/broker/memory"
+	"github.com/vatsal3003/viswals/internal/pipeline"
+	"github.com/vatsal3003/viswals/internal/service/userservice"
+	"github.com/vatsal3003/viswals/models"
+	"go.uber.org/zap"
+)
+
+// usersReader reads the users in order
+type usersReader struct {
+	users []*models.User
+	line  int
+}
+
+func (r *usersReader) Read() (*pipeline.Record, error) {
+	if r.line == len(r.users) {
+		return nil, io.EOF
+	}
+	r.line++
+	return &pipeline.Record{User: r.users[r.line-1], Source: "users.csv", Line: r.line}, nil
+}
+
+func (r *usersReader) Published(record *pipeline.Record)         {}
+func (r *usersReader) Failed(record *pipeline.Record, err error) {}
+
+// recordingStore records the users stored by consumer, it fails every batch when failBatches is set
+// and stores a user only once its parent is stored, like the database does
+type recordingStore struct {
+	mu          sync.Mutex
+	failBatches bool
+	// kept users are not written, like the users kept by conflict policy
+	kept        map[int]bool
+	batches     [][]int
+	each        []int
+	stored      []int
+	refreshed   []int
+	invalidated []int
+}
+
+func (s *recordingStore) StoreUsers(users []*models.User, lineages []models.UserLineage) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]int, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+	s.batches = append(s.batches, ids)
+
+	if s.failBatches {
+		return nil, errors.New("connection reset")
+	}
+
+	var written []int
+	for _, id := range ids {
+		if !s.kept[id] && !slices.Contains(written, id) {
+			written = append(written, id)
+		}
+	}
+	s.stored = append(s.stored, written...)
+	return written, nil
+}
+
+func (s *recordingStore) StoreUser(user *models.User, lineage models.UserLineage) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.each = append(s.each, user.ID)
+	if user.ParentUserID != nil && !slices.Contains(s.stored, *user.ParentUserID) {
+		return false, userservice.ErrMissingParent
+	}
+	s.stored = append(s.stored, user.ID)
+	return true, nil
+}
+
+func (s *recordingStore) CacheUsers(refreshed map[int][]byte, invalidated []int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id := range refreshed {
+		s.refreshed = append(s.refreshed, id)
+	}
+	s.invalidated = append(s.invalidated, invalidated...)
+}
+
+// consume will publish the users and consume them into the store in one batch, until every message is settled
+func consume(t *testing.T, store *recordingStore, users []*models.User) *memory.Broker {
+	logger := zap.NewNop()
+	messageBroker := memory.New()
+	t.Cleanup(messageBroker.Close)
+
+	_, err := pipeline.NewProducer(messageBroker, pipeline.ProducerOptions{}).Publish(context.Background(), logger, &usersReader{users: users})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	// One worker keeps the users of batch in publish order
+	consumer := pipeline.NewConsumer(messageBroker, store, pipeline.ConsumerOptions{
+		Workers:       1,
+		BatchSize:     len(users),
+		FlushInterval: time.Minute,
+		RetryBackoff:  []time.Duration{},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- consumer.Run(ctx, logger)
+	}()
+
+	assert.Eventually(t, func() bool {
+		depth, _ := messageBroker.QueueDepth()
+		return messageBroker.Idle() && depth == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-done)
+
+	return messageBroker
+}
+
+func newUser(id int, parentUserID *int) *models.User {
+	return &models.User{ID: id, FirstName: "User", LastName: "Test", EmailAddress: "user@inbox.edu", CreatedAt: time.UnixMilli(1361459822000), ParentUserID: parentUserID}
+}
+
+func TestBatch(t *testing.T) {
+	t.Run("Batch is stored at once", func(t *testing.T) {
+		store := &recordingStore{kept: map[int]bool{42: true}}
+		messageBroker := consume(t, store, []*models.User{newUser(8, nil), newUser(31, nil), newUser(8, nil), newUser(42, nil)})
+
+		assert.Equal(t, [][]int{{8, 31, 8, 42}}, store.batches)
+		assert.Empty(t, store.each)
+		assert.Empty(t, messageBroker.DeadLetters())
+
+		// Repeated and kept users are invalidated, as the cached one may not be the stored one
+		assert.Equal(t, []int{31}, store.refreshed)
+		slices.Sort(store.invalidated)
+		assert.Equal(t, []int{8, 42}, store.invalidated)
+	})
+
+	t.Run("Failed batch is stored one by one with orphans after their parents", func(t *testing.T) {
+		parentOf := func(id int) *int { return &id }
+
+		store := &recordingStore{failBatches: true}
+		messageBroker := consume(t, store, []*models.User{
+			newUser(42, parentOf(31)),
+			newUser(31, parentOf(8)),
+			newUser(8, nil),
+			newUser(55, parentOf(404)),
+		})
+
+		assert.Len(t, store.batches, 1)
+		assert.Equal(t, []int{42, 31, 8, 55, 42, 31, 55, 42, 55, 55}, store.each)
+		assert.Equal(t, []int{8, 31, 42}, store.stored)
+
+		// Orphan whose parent is not in batch fails once no orphan can be stored
+		deadLetters := messageBroker.DeadLetters()
+		if assert.Len(t, deadLetters, 1) {
+			assert.Contains(t, deadLetters[0].Headers[pipeline.HeaderFailureReason], "parent user is not stored yet:55")
+		}
+	})
+}
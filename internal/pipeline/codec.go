@@ -0,0 +1,411 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/vatsal3003/viswals/internal/consts"
+	"github.com/vatsal3003/viswals/models"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Codec encodes the envelope into message body and decodes it back, the content type of message tells which codec is used
+type Codec interface {
+	// Name is the name of codec used in configuration
+	Name() string
+	ContentType() string
+	Encode(envelope *Envelope) ([]byte, error)
+	Decode(body []byte, envelope *Envelope) error
+	// DecodeUser decodes the bare user published before the envelope, schema version 0
+	DecodeUser(body []byte, user *models.User) error
+}
+
+var (
+	// GobCodec encodes the envelope with gob, it can be used only between Go services
+	GobCodec Codec = gobCodec{}
+	// JSONCodec encodes the envelope as JSON object with the json tags of envelope and user
+	JSONCodec Codec = jsonCodec{}
+	// ProtobufCodec encodes the envelope as viswals.v1.Envelope message of proto/user.proto
+	ProtobufCodec Codec = protobufCodec{}
+
+	// DefaultCodec is used when no codec is configured
+	DefaultCodec = GobCodec
+
+	codecs = []Codec{GobCodec, JSONCodec, ProtobufCodec}
+)
+
+// CodecByName will return the codec by its name, empty uses DefaultCodec
+func CodecByName(name string) (Codec, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return DefaultCodec, nil
+	}
+
+	for _, codec := range codecs {
+		if codec.Name() == name {
+			return codec, nil
+		}
+	}
+
+	return nil, errors.New("invalid codec " + name + ", expected gob, json or protobuf")
+}
+
+// CodecFor will return the codec of content type, parameters of content type are ignored
+func CodecFor(contentType string) (Codec, error) {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	for _, codec := range codecs {
+		if codec.ContentType() == mediaType {
+			return codec, nil
+		}
+	}
+
+	return nil, errors.New("unsupported content-type " + contentType)
+}
+
+func (opts *ProducerOptions) codec() Codec {
+	if opts.Codec == nil {
+		return DefaultCodec
+	}
+	return opts.Codec
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string        { return "gob" }
+func (gobCodec) ContentType() string { return consts.ContentTypeGob }
+
+func (gobCodec) Encode(envelope *Envelope) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(envelope)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Decode(body []byte, envelope *Envelope) error {
+	return gob.NewDecoder(bytes.NewReader(body)).Decode(envelope)
+}
+
+func (gobCodec) DecodeUser(body []byte, user *models.User) error {
+	return gob.NewDecoder(bytes.NewReader(body)).Decode(user)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string        { return "json" }
+func (jsonCodec) ContentType() string { return consts.ContentTypeJSON }
+
+func (jsonCodec) Encode(envelope *Envelope) ([]byte, error) {
+	return json.Marshal(envelope)
+}
+
+func (jsonCodec) Decode(body []byte, envelope *Envelope) error {
+	return json.Unmarshal(body, envelope)
+}
+
+func (jsonCodec) DecodeUser(body []byte, user *models.User) error {
+	return json.Unmarshal(body, user)
+}
+
+// Field numbers of viswals.v1.Envelope, viswals.v1.User and google.protobuf.Timestamp messages
+const (
+	protoEnvelopeSchemaVersion protowire.Number = 1
+	protoEnvelopeSource        protowire.Number = 2
+	protoEnvelopeLine          protowire.Number = 3
+	protoEnvelopeBatchID       protowire.Number = 4
+	protoEnvelopePublishedAt   protowire.Number = 5
+	protoEnvelopeUser          protowire.Number = 6
+
+	protoUserID           protowire.Number = 1
+	protoUserFirstName    protowire.Number = 2
+	protoUserLastName     protowire.Number = 3
+	protoUserEmailAddress protowire.Number = 4
+	protoUserCreatedAt    protowire.Number = 5
+	protoUserDeletedAt    protowire.Number = 6
+	protoUserMergedAt     protowire.Number = 7
+	protoUserParentUserID protowire.Number = 8
+
+	protoTimestampSeconds protowire.Number = 1
+	protoTimestampNanos   protowire.Number = 2
+)
+
+// protobufCodec encodes the wire format of proto/user.proto directly, so no generated code is needed
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string        { return "protobuf" }
+func (protobufCodec) ContentType() string { return consts.ContentTypeProtobuf }
+
+func (protobufCodec) Encode(envelope *Envelope) ([]byte, error) {
+	var body []byte
+
+	for _, field := range []struct {
+		number protowire.Number
+		value  int
+	}{
+		{protoEnvelopeSchemaVersion, envelope.SchemaVersion},
+		{protoEnvelopeLine, envelope.Line},
+	} {
+		if field.value != 0 {
+			body = protowire.AppendTag(body, field.number, protowire.VarintType)
+			body = protowire.AppendVarint(body, uint64(field.value))
+		}
+	}
+	for _, field := range []struct {
+		number protowire.Number
+		value  string
+	}{
+		{protoEnvelopeSource, envelope.Source},
+		{protoEnvelopeBatchID, envelope.BatchID},
+	} {
+		if field.value != "" {
+			body = protowire.AppendTag(body, field.number, protowire.BytesType)
+			body = protowire.AppendString(body, field.value)
+		}
+	}
+
+	if !envelope.PublishedAt.IsZero() {
+		body = appendTimestamp(body, protoEnvelopePublishedAt, &envelope.PublishedAt)
+	}
+	if envelope.User != nil {
+		body = protowire.AppendTag(body, protoEnvelopeUser, protowire.BytesType)
+		body = protowire.AppendBytes(body, encodeProtoUser(envelope.User))
+	}
+
+	return body, nil
+}
+
+func (protobufCodec) Decode(body []byte, envelope *Envelope) error {
+	*envelope = Envelope{}
+
+	for len(body) > 0 {
+		number, typ, n := protowire.ConsumeTag(body)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		body = body[n:]
+
+		switch {
+		case (number == protoEnvelopeSchemaVersion || number == protoEnvelopeLine) && typ == protowire.VarintType:
+			value, n := protowire.ConsumeVarint(body)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			if number == protoEnvelopeSchemaVersion {
+				envelope.SchemaVersion = int(int64(value))
+			} else {
+				envelope.Line = int(int64(value))
+			}
+			body = body[n:]
+		case (number == protoEnvelopeSource || number == protoEnvelopeBatchID) && typ == protowire.BytesType:
+			value, n := protowire.ConsumeString(body)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			if number == protoEnvelopeSource {
+				envelope.Source = value
+			} else {
+				envelope.BatchID = value
+			}
+			body = body[n:]
+		case (number == protoEnvelopePublishedAt || number == protoEnvelopeUser) && typ == protowire.BytesType:
+			value, n := protowire.ConsumeBytes(body)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			var err error
+			if number == protoEnvelopePublishedAt {
+				envelope.PublishedAt, err = consumeTimestamp(value)
+			} else {
+				envelope.User = new(models.User)
+				err = decodeProtoUser(value, envelope.User)
+			}
+			if err != nil {
+				return err
+			}
+			body = body[n:]
+		default:
+			// Unknown fields are skipped, so newer producers can add fields
+			n := protowire.ConsumeFieldValue(number, typ, body)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			body = body[n:]
+		}
+	}
+
+	return nil
+}
+
+func (protobufCodec) DecodeUser(body []byte, user *models.User) error {
+	return decodeProtoUser(body, user)
+}
+
+// encodeProtoUser will encode the user as viswals.v1.User message
+func encodeProtoUser(user *models.User) []byte {
+	var body []byte
+
+	// Fields with default values are omitted like proto3 does
+	if user.ID != 0 {
+		body = protowire.AppendTag(body, protoUserID, protowire.VarintType)
+		body = protowire.AppendVarint(body, uint64(user.ID))
+	}
+	for _, field := range []struct {
+		number protowire.Number
+		value  string
+	}{
+		{protoUserFirstName, user.FirstName},
+		{protoUserLastName, user.LastName},
+		{protoUserEmailAddress, user.EmailAddress},
+	} {
+		if field.value != "" {
+			body = protowire.AppendTag(body, field.number, protowire.BytesType)
+			body = protowire.AppendString(body, field.value)
+		}
+	}
+
+	body = appendTimestamp(body, protoUserCreatedAt, &user.CreatedAt)
+	body = appendTimestamp(body, protoUserDeletedAt, user.DeletedAt)
+	body = appendTimestamp(body, protoUserMergedAt, user.MergedAt)
+
+	// parent_user_id is optional, so it is present whenever it is set
+	if user.ParentUserID != nil {
+		body = protowire.AppendTag(body, protoUserParentUserID, protowire.VarintType)
+		body = protowire.AppendVarint(body, uint64(*user.ParentUserID))
+	}
+
+	return body
+}
+
+// decodeProtoUser will decode the viswals.v1.User message into user
+func decodeProtoUser(body []byte, user *models.User) error {
+	*user = models.User{}
+
+	for len(body) > 0 {
+		number, typ, n := protowire.ConsumeTag(body)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		body = body[n:]
+
+		switch {
+		case number == protoUserID && typ == protowire.VarintType:
+			value, n := protowire.ConsumeVarint(body)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			user.ID = int(int64(value))
+			body = body[n:]
+		case (number == protoUserFirstName || number == protoUserLastName || number == protoUserEmailAddress) && typ == protowire.BytesType:
+			value, n := protowire.ConsumeString(body)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			switch number {
+			case protoUserFirstName:
+				user.FirstName = value
+			case protoUserLastName:
+				user.LastName = value
+			default:
+				user.EmailAddress = value
+			}
+			body = body[n:]
+		case (number == protoUserCreatedAt || number == protoUserDeletedAt || number == protoUserMergedAt) && typ == protowire.BytesType:
+			value, n := protowire.ConsumeBytes(body)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			timestamp, err := consumeTimestamp(value)
+			if err != nil {
+				return err
+			}
+			switch number {
+			case protoUserCreatedAt:
+				user.CreatedAt = timestamp
+			case protoUserDeletedAt:
+				user.DeletedAt = &timestamp
+			default:
+				user.MergedAt = &timestamp
+			}
+			body = body[n:]
+		case number == protoUserParentUserID && typ == protowire.VarintType:
+			value, n := protowire.ConsumeVarint(body)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			parentUserID := int(int64(value))
+			user.ParentUserID = &parentUserID
+			body = body[n:]
+		default:
+			// Unknown fields are skipped, so newer producers can add fields
+			n := protowire.ConsumeFieldValue(number, typ, body)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			body = body[n:]
+		}
+	}
+
+	return nil
+}
+
+// appendTimestamp will append the time as google.protobuf.Timestamp message field, nil time is omitted
+func appendTimestamp(body []byte, number protowire.Number, at *time.Time) []byte {
+	if at == nil {
+		return body
+	}
+
+	var timestamp []byte
+	if seconds := at.Unix(); seconds != 0 {
+		timestamp = protowire.AppendTag(timestamp, protoTimestampSeconds, protowire.VarintType)
+		timestamp = protowire.AppendVarint(timestamp, uint64(seconds))
+	}
+	if nanos := at.Nanosecond(); nanos != 0 {
+		timestamp = protowire.AppendTag(timestamp, protoTimestampNanos, protowire.VarintType)
+		timestamp = protowire.AppendVarint(timestamp, uint64(nanos))
+	}
+
+	body = protowire.AppendTag(body, number, protowire.BytesType)
+	return protowire.AppendBytes(body, timestamp)
+}
+
+// consumeTimestamp will parse google.protobuf.Timestamp message into UTC time
+func consumeTimestamp(body []byte) (time.Time, error) {
+	var seconds, nanos int64
+
+	for len(body) > 0 {
+		number, typ, n := protowire.ConsumeTag(body)
+		if n < 0 {
+			return time.Time{}, protowire.ParseError(n)
+		}
+		body = body[n:]
+
+		if (number == protoTimestampSeconds || number == protoTimestampNanos) && typ == protowire.VarintType {
+			value, n := protowire.ConsumeVarint(body)
+			if n < 0 {
+				return time.Time{}, protowire.ParseError(n)
+			}
+			if number == protoTimestampSeconds {
+				seconds = int64(value)
+			} else {
+				nanos = int64(int32(value))
+			}
+			body = body[n:]
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(number, typ, body)
+		if n < 0 {
+			return time.Time{}, protowire.ParseError(n)
+		}
+		body = body[n:]
+	}
+
+	return time.Unix(seconds, nanos).UTC(), nil
+}
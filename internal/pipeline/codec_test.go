@@ -0,0 +1,112 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vatsal3003/viswals/internal/pipeline"
+	"github.com/vatsal3003/viswals/models"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	mergedAt := createdAt.Add(time.Hour)
+	parentUserID := 7
+
+	users := map[string]*models.User{
+		"Required fields": {
+			ID:           1,
+			FirstName:    "John",
+			LastName:     "Doe",
+			EmailAddress: "john@example.com",
+			CreatedAt:    createdAt,
+		},
+		"Optional fields": {
+			ID:           8,
+			FirstName:    "Jane",
+			EmailAddress: "jane@example.com",
+			CreatedAt:    createdAt,
+			DeletedAt:    &mergedAt,
+			MergedAt:     &mergedAt,
+			ParentUserID: &parentUserID,
+		},
+	}
+
+	for _, name := range []string{"gob", "json", "protobuf"} {
+		codec, err := pipeline.CodecByName(name)
+		assert.NoError(t, err)
+
+		for userName, user := range users {
+			t.Run(name+"/"+userName, func(t *testing.T) {
+				envelope := &pipeline.Envelope{
+					SchemaVersion: pipeline.SchemaVersion,
+					Source:        "/data/users.csv",
+					Line:          42,
+					BatchID:       "batch",
+					PublishedAt:   createdAt,
+					User:          user,
+				}
+				body, err := codec.Encode(envelope)
+				assert.NoError(t, err)
+
+				// Consumer picks the codec by content type of message
+				decoder, err := pipeline.CodecFor(codec.ContentType() + "; charset=utf-8")
+				assert.NoError(t, err)
+
+				var decodedEnvelope pipeline.Envelope
+				assert.NoError(t, decoder.Decode(body, &decodedEnvelope))
+				assert.Equal(t, envelope.SchemaVersion, decodedEnvelope.SchemaVersion)
+				assert.Equal(t, envelope.Source, decodedEnvelope.Source)
+				assert.Equal(t, envelope.Line, decodedEnvelope.Line)
+				assert.Equal(t, envelope.BatchID, decodedEnvelope.BatchID)
+				assert.True(t, envelope.PublishedAt.Equal(decodedEnvelope.PublishedAt))
+				if !assert.NotNil(t, decodedEnvelope.User) {
+					return
+				}
+
+				decoded := *decodedEnvelope.User
+				assert.Equal(t, user.ID, decoded.ID)
+				assert.Equal(t, user.FirstName, decoded.FirstName)
+				assert.Equal(t, user.LastName, decoded.LastName)
+				assert.Equal(t, user.EmailAddress, decoded.EmailAddress)
+				assert.True(t, user.CreatedAt.Equal(decoded.CreatedAt))
+				assert.Equal(t, user.DeletedAt == nil, decoded.DeletedAt == nil)
+				assert.Equal(t, user.MergedAt == nil, decoded.MergedAt == nil)
+				if user.MergedAt != nil && decoded.MergedAt != nil {
+					assert.True(t, user.MergedAt.Equal(*decoded.MergedAt))
+				}
+				assert.Equal(t, user.ParentUserID, decoded.ParentUserID)
+			})
+		}
+	}
+}
+
+func TestCodecLookup(t *testing.T) {
+	codec, err := pipeline.CodecByName("")
+	assert.NoError(t, err)
+	assert.Equal(t, pipeline.DefaultCodec, codec)
+
+	_, err = pipeline.CodecByName("xml")
+	assert.Error(t, err)
+
+	_, err = pipeline.CodecFor("text/plain")
+	assert.Error(t, err)
+
+	// Truncated protobuf message is reported
+	var envelope pipeline.Envelope
+	assert.Error(t, pipeline.ProtobufCodec.Decode([]byte{0x32, 0x05, 'J'}, &envelope))
+}
+
+func TestCodecDecodeUser(t *testing.T) {
+	// Messages of schema version 0 are bare gob encoded users
+	var buf bytes.Buffer
+	assert.NoError(t, gob.NewEncoder(&buf).Encode(&models.User{ID: 3, FirstName: "Legacy"}))
+
+	var user models.User
+	assert.NoError(t, pipeline.GobCodec.DecodeUser(buf.Bytes(), &user))
+	assert.Equal(t, 3, user.ID)
+	assert.Equal(t, "Legacy", user.FirstName)
+}
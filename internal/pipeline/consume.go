@@ -0,0 +1,205 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vatsal3003/viswals/internal/broker"
+	"github.com/vatsal3003/viswals/internal/encryption"
+	"github.com/vatsal3003/viswals/models"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultWorkers is the number of messages processed at once by consumer
+	defaultWorkers = 10
+	// prefetchPerWorker is the number of unacknowledged messages delivered to consumer for every worker
+	prefetchPerWorker = 2
+)
+
+type ConsumerOptions struct {
+	// Workers is the number of messages decoded at once by consumer, broker delivers at most prefetchPerWorker
+	// unacknowledged messages for every worker on top of a batch, so slow storage slows down the deliveries
+	Workers int
+
+	// BatchSize is the number of users stored in one transaction, a batch is stored once it is full or FlushInterval is passed
+	BatchSize     int
+	FlushInterval time.Duration
+
+	// RetryBackoff is the delay before each retry of a message failed with transient error
+	// message is moved to dead letter queue after all retries, nil uses DefaultRetryBackoff and empty disables retries
+	RetryBackoff []time.Duration
+}
+
+func (opts *ConsumerOptions) workers() int {
+	if opts.Workers <= 0 {
+		return defaultWorkers
+	}
+	return opts.Workers
+}
+
+// ConsumerStats is the snapshot of consumer pipeline
+type ConsumerStats struct {
+	// Workers is the size of worker pool and Prefetch is the number of unacknowledged messages broker delivers at once
+	Workers  int `json:"workers"`
+	Prefetch int `json:"prefetch"`
+	// InFlight is the number of messages being processed by workers
+	InFlight int64 `json:"in_flight"`
+	// Buffered is the number of delivered messages waiting for a free worker
+	Buffered int64 `json:"buffered"`
+	// BatchSize is the number of users stored at once and Batched is the number of decoded users waiting in batch
+	BatchSize int   `json:"batch_size"`
+	Batched   int64 `json:"batched"`
+}
+
+// Consumer stores the users consumed from the broker
+type Consumer struct {
+	subscriber broker.Subscriber
+	store      Store
+	opts       ConsumerOptions
+
+	inFlight atomic.Int64
+	buffered atomic.Int64
+	batched  atomic.Int64
+}
+
+// NewConsumer will create the consumer which stores the users delivered by subscriber into store
+func NewConsumer(subscriber broker.Subscriber, store Store, opts ConsumerOptions) *Consumer {
+	return &Consumer{subscriber: subscriber, store: store, opts: opts}
+}
+
+// Run will consume messages from the queue and store the users
+// messages are decoded by a pool of workers and stored in batches, the prefetch is tied to the pool and batch size,
+// so broker does not deliver more messages than the workers and database can handle
+// message is acknowledged only after its batch is committed, failed messages are retried or moved to dead letter queue
+// when the deliveries are closed, it subscribes again, it returns once ctx is done or the broker is closed
+func (c *Consumer) Run(ctx context.Context, logger *zap.Logger) error {
+	workers, prefetch := c.opts.workers(), c.opts.prefetch()
+
+	for {
+		deliveries, err := c.subscriber.Subscribe(ctx, prefetch)
+		if errors.Is(err, broker.ErrClosed) || ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			logger.Error("failed to consume messages:" + err.Error())
+			return err
+		}
+
+		logger.Info("consuming messages",
+			zap.Int("workers", workers),
+			zap.Int("prefetch", prefetch),
+			zap.Int("batch_size", c.opts.batchSize()),
+		)
+
+		c.dispatch(logger, deliveries, workers, prefetch)
+
+		if ctx.Err() != nil {
+			return nil
+		}
+		// Unacknowledged messages of closed deliveries are delivered again by broker
+		logger.Warn("deliveries are closed, consuming again")
+	}
+}
+
+// dispatch will hand over the deliveries to the workers and their decoded users to the batch,
+// it waits for the workers and the last batch once deliveries are closed
+func (c *Consumer) dispatch(logger *zap.Logger, deliveries <-chan broker.Delivery, workers, prefetch int) {
+	jobs := make(chan broker.Delivery, prefetch)
+	decoded := make(chan decodedMessage)
+
+	batchDone := make(chan struct{})
+	go func() {
+		defer close(batchDone)
+		c.batch(logger, decoded)
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for range workers {
+		go func() {
+			defer wg.Done()
+			for delivery := range jobs {
+				c.buffered.Add(-1)
+				c.inFlight.Add(1)
+				c.handle(logger, delivery, decoded)
+				c.inFlight.Add(-1)
+			}
+		}()
+	}
+
+	for delivery := range deliveries {
+		c.buffered.Add(1)
+		jobs <- delivery
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	close(decoded)
+	<-batchDone
+}
+
+// handle will decode the message and pass it to the batch, or fail it when it can not be decoded
+func (c *Consumer) handle(logger *zap.Logger, delivery broker.Delivery, decoded chan<- decodedMessage) {
+	decodedMessage, err := decodeMessage(delivery)
+	if err != nil {
+		logger.Error("failed to process message:" + err.Error())
+		c.fail(logger, delivery, err)
+		return
+	}
+
+	decoded <- decodedMessage
+}
+
+// Stats will return the snapshot of consumer pipeline
+func (c *Consumer) Stats() ConsumerStats {
+	return ConsumerStats{
+		Workers:   c.opts.workers(),
+		Prefetch:  c.opts.prefetch(),
+		InFlight:  c.inFlight.Load(),
+		Buffered:  c.buffered.Load(),
+		BatchSize: c.opts.batchSize(),
+		Batched:   c.batched.Load(),
+	}
+}
+
+// decodeMessage will decode the envelope from message and encrypt the email address of its user
+// invalid message is failed with permanent error
+func decodeMessage(delivery broker.Delivery) (decodedMessage, error) {
+	envelope, version, err := decodeEnvelope(delivery.Message())
+	if err != nil {
+		return decodedMessage{}, permanent(err)
+	}
+	user := envelope.User
+
+	user.EmailAddress, err = encryption.Encrypt(user.EmailAddress)
+	if err != nil {
+		return decodedMessage{}, permanent(errors.New("failed to encrypt the user email address:" + err.Error()))
+	}
+
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(user)
+	if err != nil {
+		return decodedMessage{}, permanent(errors.New("failed to encode user into gob stream:" + err.Error()))
+	}
+
+	return decodedMessage{
+		delivery: delivery,
+		user:     user,
+		cached:   buf.Bytes(),
+		lineage: models.UserLineage{
+			UserID:        user.ID,
+			SchemaVersion: version,
+			Source:        envelope.Source,
+			Line:          envelope.Line,
+			BatchID:       envelope.BatchID,
+			PublishedAt:   envelope.PublishedAt,
+		},
+	}, nil
+}
@@ -0,0 +1,108 @@
+package pipeline
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/vatsal3003/viswals/internal/broker"
+	"github.com/vatsal3003/viswals/models"
+)
+
+// HeaderSchemaVersion is the schema version of message body, only legacy gob messages of bare users, version 0, are without it
+const HeaderSchemaVersion = "x-schema-version"
+
+// SchemaVersion is the schema version of published envelopes
+const SchemaVersion = 1
+
+// Envelope wraps the published user with its origin
+type Envelope struct {
+	SchemaVersion int    `json:"schema_version"`
+	Source        string `json:"source"`
+	// Line is the line of source where the user ends
+	Line int `json:"line"`
+	// BatchID is shared by the users published by one ingestion
+	BatchID     string       `json:"batch_id"`
+	PublishedAt time.Time    `json:"published_at"`
+	User        *models.User `json:"user"`
+}
+
+// upgrades will upgrade the envelope of every older schema version to the next version
+var upgrades = map[int]func(envelope *Envelope){
+	// Version 0 was a bare user, its origin is unknown
+	0: func(envelope *Envelope) {
+		envelope.SchemaVersion = 1
+	},
+}
+
+// decodeEnvelope will decode the envelope from message with the codec of its content type
+// and upgrade it to the current schema version, it returns the schema version message is published with
+func decodeEnvelope(message broker.Message) (*Envelope, int, error) {
+	codec, err := CodecFor(message.ContentType)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	version, err := schemaVersion(message.Headers, codec)
+	if err != nil {
+		return nil, version, err
+	}
+	if version < 0 || version > SchemaVersion {
+		return nil, version, errors.New("unsupported schema version " + strconv.Itoa(version))
+	}
+
+	envelope := &Envelope{SchemaVersion: version}
+	if version == 0 {
+		envelope.User = new(models.User)
+		envelope.PublishedAt = message.Timestamp
+		err = codec.DecodeUser(message.Body, envelope.User)
+	} else {
+		err = codec.Decode(message.Body, envelope)
+	}
+	if err != nil {
+		return nil, version, errors.New("failed to decode message with " + codec.Name() + " codec:" + err.Error())
+	}
+	if envelope.User == nil {
+		return nil, version, errors.New("message has no user")
+	}
+	envelope.SchemaVersion = version
+
+	for envelope.SchemaVersion < SchemaVersion {
+		upgrades[envelope.SchemaVersion](envelope)
+	}
+
+	return envelope, version, nil
+}
+
+// schemaVersion will return the schema version from message headers, a message without the header is a bare user of version 0,
+// which was only published with gob codec, so the message of any other codec has to set the header
+func schemaVersion(headers map[string]any, codec Codec) (int, error) {
+	value, ok := headers[HeaderSchemaVersion]
+	if !ok {
+		if codec != GobCodec {
+			return 0, errors.New("message has no " + HeaderSchemaVersion + " header, only legacy gob messages can be without it")
+		}
+		return 0, nil
+	}
+
+	var version int
+	switch value := value.(type) {
+	case int:
+		version = value
+	case int8:
+		version = int(value)
+	case int16:
+		version = int(value)
+	case int32:
+		version = int(value)
+	case int64:
+		version = int(value)
+	default:
+		return 0, errors.New("invalid " + HeaderSchemaVersion + " header, expected an integer")
+	}
+
+	if version == 0 && codec != GobCodec {
+		return version, errors.New("schema version 0 is only published with gob codec, not with " + codec.Name() + " codec")
+	}
+	return version, nil
+}
@@ -0,0 +1,114 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vatsal3003/viswals/internal/broker"
+	"github.com/vatsal3003/viswals/internal/broker/memory"
+	"github.com/vatsal3003/viswals/internal/consts"
+	"github.com/vatsal3003/viswals/internal/pipeline"
+	"go.uber.org/zap"
+)
+
+func TestSchemaVersion(t *testing.T) {
+	envelope := func(id int) []byte {
+		body, err := pipeline.JSONCodec.Encode(&pipeline.Envelope{SchemaVersion: pipeline.SchemaVersion, User: newUser(id, nil)})
+		assert.NoError(t, err)
+		return body
+	}
+
+	var legacy bytes.Buffer
+	assert.NoError(t, gob.NewEncoder(&legacy).Encode(newUser(1, nil)))
+	bareUser, err := json.Marshal(newUser(5, nil))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		message     broker.Message
+		wantStored  bool
+		wantFailure string
+	}{
+		{
+			name:       "Legacy gob message without header is version 0",
+			message:    broker.Message{ContentType: consts.ContentTypeGob, Body: legacy.Bytes()},
+			wantStored: true,
+		},
+		{
+			name:        "Missing header",
+			message:     broker.Message{ContentType: consts.ContentTypeJSON, Body: envelope(2)},
+			wantFailure: "message has no x-schema-version header",
+		},
+		{
+			name:        "Invalid header",
+			message:     broker.Message{Headers: map[string]any{pipeline.HeaderSchemaVersion: "1"}, ContentType: consts.ContentTypeJSON, Body: envelope(3)},
+			wantFailure: "invalid x-schema-version header",
+		},
+		{
+			name:        "Unknown version",
+			message:     broker.Message{Headers: map[string]any{pipeline.HeaderSchemaVersion: int32(2)}, ContentType: consts.ContentTypeJSON, Body: envelope(4)},
+			wantFailure: "unsupported schema version 2",
+		},
+		{
+			name:        "Version 0 of other codec",
+			message:     broker.Message{Headers: map[string]any{pipeline.HeaderSchemaVersion: int32(0)}, ContentType: consts.ContentTypeJSON, Body: bareUser},
+			wantFailure: "schema version 0 is only published with gob codec",
+		},
+		{
+			name:       "Current version",
+			message:    broker.Message{Headers: map[string]any{pipeline.HeaderSchemaVersion: int32(pipeline.SchemaVersion)}, ContentType: consts.ContentTypeJSON, Body: envelope(6)},
+			wantStored: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messageBroker := memory.New()
+			defer messageBroker.Close()
+
+			_, err := messageBroker.Publish(context.Background(), tt.message)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+
+			store := &recordingStore{}
+			consumer := pipeline.NewConsumer(messageBroker, store, pipeline.ConsumerOptions{
+				Workers:       1,
+				BatchSize:     1,
+				FlushInterval: time.Minute,
+				RetryBackoff:  []time.Duration{},
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() {
+				done <- consumer.Run(ctx, zap.NewNop())
+			}()
+
+			assert.Eventually(t, func() bool {
+				depth, _ := messageBroker.QueueDepth()
+				return messageBroker.Idle() && depth == 0
+			}, 5*time.Second, 10*time.Millisecond)
+
+			cancel()
+			assert.NoError(t, <-done)
+
+			deadLetters := messageBroker.DeadLetters()
+			if tt.wantStored {
+				assert.Len(t, store.stored, 1)
+				assert.Empty(t, deadLetters)
+				return
+			}
+
+			assert.Empty(t, store.stored)
+			if assert.Len(t, deadLetters, 1) {
+				assert.Contains(t, deadLetters[0].Headers[pipeline.HeaderFailureReason], tt.wantFailure)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/vatsal3003/viswals/internal/broker"
+	"github.com/vatsal3003/viswals/models"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultConfirmWindow is the number of published messages which can wait for confirmation at once
+	defaultConfirmWindow = 256
+	// defaultMaxPublishRetries is the number of times a nacked message is published again
+	defaultMaxPublishRetries = 3
+)
+
+// ErrNacked is reported for the message which is nacked by broker after all retries
+var ErrNacked = errors.New("message is nacked by broker")
+
+// Record is a user read from the source with the position of its end in the source
+// User is only valid until the next read, Row and position are kept until the record is confirmed
+type Record struct {
+	User   *models.User
+	Row    []string
+	Source string
+	Line   int
+	Offset int64
+}
+
+// UserReader reads user records one by one, it returns io.EOF when there is no record left
+type UserReader interface {
+	Read() (*Record, error)
+	// Published is called in read order for every record once it is confirmed by broker
+	Published(record *Record)
+	// Failed is called in read order for every record which is not confirmed after all retries
+	Failed(record *Record, err error)
+}
+
+// PublishSummary contains the batch id and the counts of published, confirmed and failed messages
+type PublishSummary struct {
+	BatchID   string
+	Published int
+	Confirmed int
+	Failed    int
+}
+
+type ProducerOptions struct {
+	// ConfirmWindow is the number of published messages which can wait for confirmation at once
+	ConfirmWindow int
+	// MaxPublishRetries is the number of times a nacked message is published again before it is failed, negative disables retries
+	MaxPublishRetries int
+
+	// Codec encodes the published users, consumer decodes every supported codec by content type of message
+	Codec Codec
+}
+
+func (opts *ProducerOptions) confirmWindow() int {
+	if opts.ConfirmWindow <= 0 {
+		return defaultConfirmWindow
+	}
+	return opts.ConfirmWindow
+}
+
+func (opts *ProducerOptions) maxPublishRetries() int {
+	if opts.MaxPublishRetries < 0 {
+		return 0
+	}
+	if opts.MaxPublishRetries == 0 {
+		return defaultMaxPublishRetries
+	}
+	return opts.MaxPublishRetries
+}
+
+// Producer publishes the users read from a source to the broker
+type Producer struct {
+	publisher broker.Publisher
+	opts      ProducerOptions
+}
+
+// NewProducer will create the producer which publishes on the publisher
+func NewProducer(publisher broker.Publisher, opts ProducerOptions) *Producer {
+	return &Producer{publisher: publisher, opts: opts}
+}
+
+// pendingMessage is a published message waiting for confirmation from broker
+type pendingMessage struct {
+	record       Record
+	body         []byte
+	confirmation broker.Confirmation
+}
+
+// Publish will publish every user of reader in an envelope to the queue with publisher confirms
+// envelopes of one call share a batch id, it returns once every published message is confirmed by the broker or failed after retries
+// when the context is cancelled no more users are read, the published messages are still settled and the context error is returned
+func (p *Producer) Publish(ctx context.Context, logger *zap.Logger, reader UserReader) (PublishSummary, error) {
+	summary := PublishSummary{BatchID: newBatchID()}
+
+	codec := p.opts.codec()
+	var pending []*pendingMessage
+
+	for ctx.Err() == nil {
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			logger.Error("failed to read user:" + err.Error())
+			return summary, errors.Join(err, p.settle(logger, reader, pending, &summary))
+		}
+
+		body, err := codec.Encode(&Envelope{
+			SchemaVersion: SchemaVersion,
+			Source:        record.Source,
+			Line:          record.Line,
+			BatchID:       summary.BatchID,
+			PublishedAt:   time.Now().UTC(),
+			User:          record.User,
+		})
+		if err != nil {
+			logger.Error("failed to encode user data with " + codec.Name() + " codec:" + err.Error())
+			return summary, errors.Join(err, p.settle(logger, reader, pending, &summary))
+		}
+
+		message := &pendingMessage{
+			record: *record,
+			body:   body,
+		}
+		message.record.User = nil
+
+		message.confirmation, err = p.publish(ctx, message.body)
+		if err != nil {
+			logger.Error("failed to publish message:" + err.Error())
+			return summary, errors.Join(err, p.settle(logger, reader, pending, &summary))
+		}
+		summary.Published++
+		pending = append(pending, message)
+
+		// Wait for the oldest confirmations when too many messages are outstanding
+		if len(pending) >= p.opts.confirmWindow() {
+			err = p.settle(logger, reader, pending[:1], &summary)
+			if err != nil {
+				return summary, errors.Join(err, p.settle(logger, reader, pending[1:], &summary))
+			}
+			pending = pending[1:]
+		}
+	}
+
+	err := p.settle(logger, reader, pending, &summary)
+
+	logger.Info("messages published", zap.String("batch_id", summary.BatchID), zap.Int("published", summary.Published), zap.Int("confirmed", summary.Confirmed), zap.Int("failed", summary.Failed))
+
+	return summary, errors.Join(ctx.Err(), err)
+}
+
+// publish will publish the message body with the codec content type and schema version
+func (p *Producer) publish(ctx context.Context, body []byte) (broker.Confirmation, error) {
+	return p.publisher.Publish(ctx, broker.Message{
+		Headers:     map[string]any{HeaderSchemaVersion: int32(SchemaVersion)},
+		ContentType: p.opts.codec().ContentType(),
+		Timestamp:   time.Now(),
+		Body:        body,
+	})
+}
+
+// settle will wait for the confirmation of pending messages in order, nacked messages are published again
+// readers are notified in read order, so a confirmed record is never reported before the records read earlier
+// settling stops at the first message which can not be published again, as its publisher is unusable
+func (p *Producer) settle(logger *zap.Logger, reader UserReader, pending []*pendingMessage, summary *PublishSummary) error {
+	for i, message := range pending {
+		acked, err := message.confirmation.Wait(context.Background())
+
+		for attempt := 1; err == nil && !acked && attempt <= p.opts.maxPublishRetries(); attempt++ {
+			logger.Warn("message is nacked by broker, publishing again", zap.Int("line", message.record.Line), zap.Int("attempt", attempt))
+
+			var confirmation broker.Confirmation
+			confirmation, err = p.publish(context.Background(), message.body)
+			if err != nil {
+				break
+			}
+			acked, err = confirmation.Wait(context.Background())
+		}
+		if err != nil {
+			logger.Error("failed to publish nacked message:" + err.Error())
+			summary.Failed += len(pending) - i
+			return err
+		}
+
+		if !acked {
+			summary.Failed++
+			reader.Failed(&message.record, ErrNacked)
+			continue
+		}
+
+		summary.Confirmed++
+		reader.Published(&message.record)
+	}
+
+	return nil
+}
+
+// newBatchID will return a random id for the batch of published messages
+func newBatchID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,132 @@
+package pipeline
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/vatsal3003/viswals/internal/broker"
+	"go.uber.org/zap"
+)
+
+const (
+	// HeaderRetryCount is the number of times a message is retried
+	HeaderRetryCount = "x-retry-count"
+	// HeaderFailureReason and HeaderFailedAt are set on the messages moved to dead letter queue
+	HeaderFailureReason = "x-failure-reason"
+	HeaderFailedAt      = "x-failed-at"
+)
+
+// DefaultRetryBackoff is used when no retry backoff is configured
+var DefaultRetryBackoff = []time.Duration{time.Second, 10 * time.Second, time.Minute}
+
+// permanentError is the failure which will fail again on retry, e.g. invalid message
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// permanent marks the error as permanent, so the message is moved to dead letter queue without retries
+func permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+func isPermanent(err error) bool {
+	var permanentErr *permanentError
+	return errors.As(err, &permanentErr)
+}
+
+func (opts *ConsumerOptions) retryBackoff() []time.Duration {
+	if opts.RetryBackoff == nil {
+		return DefaultRetryBackoff
+	}
+	return opts.RetryBackoff
+}
+
+// fail will retry the message failed with transient error after its backoff,
+// and move it to dead letter queue when it is failed permanently or all retries are exhausted
+func (c *Consumer) fail(logger *zap.Logger, delivery broker.Delivery, reason error) {
+	backoff := c.opts.retryBackoff()
+	attempt := retryCount(delivery.Message().Headers)
+
+	if isPermanent(reason) || attempt >= len(backoff) {
+		c.deadLetter(logger, delivery, reason)
+		return
+	}
+
+	err := delivery.Retry(backoff[attempt], map[string]any{
+		HeaderRetryCount:    int32(attempt + 1),
+		HeaderFailureReason: reason.Error(),
+	})
+	if err != nil {
+		logger.Error("failed to publish message to retry queue:" + err.Error())
+		c.deadLetter(logger, delivery, reason)
+		return
+	}
+
+	logger.Warn("message scheduled for retry", zap.Int("attempt", attempt+1), zap.Duration("delay", backoff[attempt]))
+}
+
+// deadLetter will move the message with failure reason to the dead letter queue
+// if it can not be moved, the message is rejected, so the broker dead letters it without the reason
+func (c *Consumer) deadLetter(logger *zap.Logger, delivery broker.Delivery, reason error) {
+	err := delivery.DeadLetter(map[string]any{
+		HeaderFailureReason: reason.Error(),
+		HeaderFailedAt:      time.Now().UTC().Format(time.RFC3339),
+	})
+	if err == nil {
+		return
+	}
+	logger.Error("failed to publish message to dead letter exchange:" + err.Error())
+
+	err = delivery.Nack(false)
+	if err != nil {
+		logger.Error("failed to reject message:" + err.Error())
+	}
+}
+
+// retryCount will return the number of retries from message headers
+func retryCount(headers map[string]any) int {
+	switch count := headers[HeaderRetryCount].(type) {
+	case int:
+		return count
+	case int32:
+		return int(count)
+	case int64:
+		return int(count)
+	default:
+		return 0
+	}
+}
+
+// ParseBackoff will parse comma separated durations, none disables the retries
+func ParseBackoff(backoff string) ([]time.Duration, error) {
+	backoff = strings.TrimSpace(backoff)
+	if backoff == "" {
+		return nil, nil
+	}
+	if backoff == "none" {
+		return []time.Duration{}, nil
+	}
+
+	var delays []time.Duration
+	for _, value := range strings.Split(backoff, ",") {
+		delay, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return nil, err
+		}
+		if delay < time.Millisecond {
+			return nil, errors.New("retry backoff " + value + " must be at least 1ms")
+		}
+		delays = append(delays, delay)
+	}
+
+	return delays, nil
+}
@@ -0,0 +1,56 @@
+package pipeline_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vatsal3003/viswals/internal/pipeline"
+)
+
+func TestParseBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []time.Duration
+		wantErr bool
+	}{
+		{
+			name:  "Empty uses default",
+			input: "",
+			want:  nil,
+		},
+		{
+			name:  "Disabled",
+			input: "none",
+			want:  []time.Duration{},
+		},
+		{
+			name:  "Schedule",
+			input: "500ms, 5s,1m",
+			want:  []time.Duration{500 * time.Millisecond, 5 * time.Second, time.Minute},
+		},
+		{
+			name:    "Invalid duration",
+			input:   "5s,soon",
+			wantErr: true,
+		},
+		{
+			name:    "Too small duration",
+			input:   "0s",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backoff, err := pipeline.ParseBackoff(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, backoff)
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package pipeline
+
+import (
+	"errors"
+
+	"github.com/vatsal3003/viswals/internal/cache"
+	"github.com/vatsal3003/viswals/internal/database"
+	"github.com/vatsal3003/viswals/internal/service/userservice"
+	"github.com/vatsal3003/viswals/models"
+)
+
+// Store keeps the consumed users with their lineage
+type Store interface {
+	// StoreUsers will store the users with their lineage at once and return the ids of written users
+	StoreUsers(users []*models.User, lineages []models.UserLineage) ([]int, error)
+	// StoreUser will store the user with its lineage and report whether it is written, it fails with
+	// userservice.ErrMissingParent when the parent user is not stored yet and with permanent error when the user is invalid
+	StoreUser(user *models.User, lineage models.UserLineage) (bool, error)
+	// CacheUsers will set the gob encoded refreshed users in cache and remove the invalidated ones
+	CacheUsers(refreshed map[int][]byte, invalidated []int)
+}
+
+// DatabaseStore stores the users into the user repository of database and caches them,
+// the written users are broadcast to the subscribers of hub
+type DatabaseStore struct {
+	users  userservice.UserRepository
+	cache  cache.Cache
+	policy userservice.ConflictPolicy
+	hub    *userservice.Hub
+}
+
+// NewDatabaseStore will create the store which resolves the existing users by the policy, hub can be nil
+func NewDatabaseStore(db *database.Database, userCache cache.Cache, policy userservice.ConflictPolicy, hub *userservice.Hub) *DatabaseStore {
+	if policy == "" {
+		policy = userservice.DefaultConflictPolicy
+	}
+	return &DatabaseStore{users: userservice.NewUserRepository(db), cache: userCache, policy: policy, hub: hub}
+}
+
+func (s *DatabaseStore) StoreUsers(users []*models.User, lineages []models.UserLineage) ([]int, error) {
+	written, err := s.users.InsertUsers(users, lineages, s.policy)
+	if err != nil {
+		return nil, err
+	}
+
+	s.broadcast(users, written)
+	return written, nil
+}
+
+func (s *DatabaseStore) StoreUser(user *models.User, lineage models.UserLineage) (bool, error) {
+	written, err := s.users.InsertUser(user, lineage, s.policy)
+	if errors.Is(err, userservice.ErrInvalidUser) {
+		return false, permanent(err)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if written {
+		s.broadcast([]*models.User{user}, []int{user.ID})
+	}
+	return written, nil
+}
+
+// broadcast will publish the written users of batch to the hub
+// a user repeated in batch is read from database, as the conflict policy decides which one of them is written
+func (s *DatabaseStore) broadcast(users []*models.User, written []int) {
+	if s.hub == nil || len(written) == 0 {
+		return
+	}
+
+	byID := make(map[int]*models.User, len(users))
+	repeated := make(map[int]bool)
+	for _, user := range users {
+		if _, ok := byID[user.ID]; ok {
+			repeated[user.ID] = true
+		}
+		byID[user.ID] = user
+	}
+
+	published := make([]*models.User, 0, len(written))
+	for _, id := range written {
+		user := byID[id]
+		if repeated[id] {
+			stored, err := s.users.GetUser(id)
+			if err != nil {
+				continue
+			}
+			user = stored
+		}
+		published = append(published, user)
+	}
+
+	userservice.PublishUsers(s.hub, published)
+}
+
+func (s *DatabaseStore) CacheUsers(refreshed map[int][]byte, invalidated []int) {
+	_ = userservice.InsertUsersInKVStore(s.cache, refreshed)
+	_ = userservice.DeleteUsersFromKVStore(s.cache, invalidated)
+}
@@ -0,0 +1,160 @@
+package rabbitmq
+
+import (
+	"context"
+	"maps"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/vatsal3003/viswals/internal/broker"
+)
+
+// Subscribe will consume the messages of the queue with manual acknowledgement and prefetch
+// deliveries are closed when the channel is closed, unacknowledged messages are delivered again by broker
+// when the connection is lost, it waits for the reconnection
+func (rmq *RabbitMQ) Subscribe(ctx context.Context, prefetch int) (<-chan broker.Delivery, error) {
+	for {
+		channel, err := rmq.currentChannel(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		messages, err := rmq.consume(ctx, channel, prefetch)
+		if err != nil {
+			rmq.logger.Error("failed to consume messages:" + err.Error())
+			if !channel.IsClosed() {
+				return nil, err
+			}
+			// Channel is closed, consume again after reconnection
+			continue
+		}
+
+		deliveries := make(chan broker.Delivery)
+		go func() {
+			defer close(deliveries)
+			for message := range messages {
+				deliveries <- &delivery{rmq: rmq, channel: channel, message: message}
+			}
+		}()
+
+		return deliveries, nil
+	}
+}
+
+// consume will set the prefetch of channel and start consuming the queue on it
+func (rmq *RabbitMQ) consume(ctx context.Context, channel *amqp.Channel, prefetch int) (<-chan amqp.Delivery, error) {
+	err := channel.Qos(
+		prefetch, // prefetch count
+		0,        // prefetch size
+		false,    // global
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return channel.ConsumeWithContext(
+		ctx,           // context
+		rmq.queueName, // queue
+		"",            // consumer
+		false,         // auto acknowledge
+		false,         // exclusive
+		false,         // no-local
+		false,         // no-wait
+		nil,           // args
+	)
+}
+
+// delivery is a message delivered on the channel, it is settled on the same channel
+type delivery struct {
+	rmq     *RabbitMQ
+	channel *amqp.Channel
+	message amqp.Delivery
+}
+
+func (d *delivery) Message() broker.Message {
+	return broker.Message{
+		Headers:     d.message.Headers,
+		ContentType: d.message.ContentType,
+		Timestamp:   d.message.Timestamp,
+		Body:        d.message.Body,
+	}
+}
+
+func (d *delivery) Ack() error {
+	return d.message.Ack(false)
+}
+
+func (d *delivery) Nack(requeue bool) error {
+	return d.message.Nack(false, requeue)
+}
+
+// Retry will publish the message to the retry queue of delay and acknowledge it once the publish is confirmed
+// the message expires in retry queue after the delay and is dead lettered back to the queue
+func (d *delivery) Retry(delay time.Duration, headers map[string]any) error {
+	retryQueue, err := d.rmq.declareRetryQueue(d.channel, delay)
+	if err != nil {
+		return err
+	}
+
+	err = d.republish("", retryQueue, headers)
+	if err != nil {
+		return err
+	}
+
+	return d.message.Ack(false)
+}
+
+// DeadLetter will publish the message to the dead letter exchange and acknowledge it once the publish is confirmed,
+// the message is left unsettled when it is not confirmed, so it can be rejected to the dead letter exchange of queue
+func (d *delivery) DeadLetter(headers map[string]any) error {
+	err := d.republish(d.rmq.opts.DeadLetterExchange, d.message.RoutingKey, headers)
+	if err != nil {
+		return err
+	}
+
+	return d.message.Ack(false)
+}
+
+// republish will publish the message with the headers added to its own headers in confirm mode and wait for its confirmation,
+// so the message is not lost when it is acknowledged after it
+func (d *delivery) republish(exchange, key string, headers map[string]any) error {
+	merged := amqp.Table{}
+	maps.Copy(merged, d.message.Headers)
+	maps.Copy(merged, headers)
+
+	err := d.rmq.confirmMode(d.channel)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), republishTimeout)
+	defer cancel()
+
+	deferred, err := d.channel.PublishWithDeferredConfirmWithContext(
+		ctx,      // context
+		exchange, // exchange
+		key,      // key
+		false,    // mandatory
+		false,    // immediate
+		amqp.Publishing{
+			Headers:      merged,
+			ContentType:  d.message.ContentType,
+			DeliveryMode: amqp.Persistent,
+			Timestamp:    d.message.Timestamp,
+			Body:         d.message.Body,
+		}, // message
+	)
+	if err != nil {
+		return err
+	}
+
+	acked, err := deferred.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return ErrNotConfirmed
+	}
+
+	return nil
+}
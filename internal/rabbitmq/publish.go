@@ -0,0 +1,89 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/vatsal3003/viswals/internal/broker"
+)
+
+// confirmation is the deferred confirmation of a message published in confirm mode
+type confirmation struct {
+	deferred *amqp.DeferredConfirmation
+}
+
+// Wait will wait for the broker to ack or nack the message, messages of a closed channel are nacked
+func (c confirmation) Wait(ctx context.Context) (bool, error) {
+	return c.deferred.WaitContext(ctx)
+}
+
+// Publish will publish the persistent message to the queue on the current channel in confirm mode
+// when the connection is lost, it waits for the reconnection and publishes on the new channel
+func (rmq *RabbitMQ) Publish(ctx context.Context, message broker.Message) (broker.Confirmation, error) {
+	for {
+		channel, err := rmq.confirmModeChannel(ctx)
+		if errors.Is(err, amqp.ErrClosed) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		deferred, err := channel.PublishWithDeferredConfirmWithContext(
+			ctx,           // context
+			"",            // exchange
+			rmq.queueName, // key
+			false,         // mandatory
+			false,         // immediate
+			amqp.Publishing{
+				Headers:      amqp.Table(message.Headers),
+				ContentType:  message.ContentType,
+				DeliveryMode: amqp.Persistent,
+				Timestamp:    message.Timestamp,
+				Body:         message.Body,
+			}, // message
+		)
+		if errors.Is(err, amqp.ErrClosed) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return confirmation{deferred: deferred}, nil
+	}
+}
+
+// confirmModeChannel will return the current channel after putting it in confirm mode, so broker acks or nacks every message
+func (rmq *RabbitMQ) confirmModeChannel(ctx context.Context) (*amqp.Channel, error) {
+	channel, err := rmq.currentChannel(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = rmq.confirmMode(channel)
+	if err != nil {
+		return nil, err
+	}
+
+	return channel, nil
+}
+
+// confirmMode will put the channel in confirm mode once, the channel is shared by publishing and consuming
+func (rmq *RabbitMQ) confirmMode(channel *amqp.Channel) error {
+	rmq.mu.Lock()
+	defer rmq.mu.Unlock()
+
+	if rmq.confirmChannel == channel {
+		return nil
+	}
+
+	err := channel.Confirm(false)
+	if err != nil {
+		return err
+	}
+	rmq.confirmChannel = channel
+
+	return nil
+}
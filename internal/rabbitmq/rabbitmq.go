@@ -1,30 +1,62 @@
 package rabbitmq
 
 import (
-	"bytes"
 	"context"
-	"encoding/csv"
-	"encoding/gob"
 	"errors"
-	"io"
 	"log"
 	"os"
-	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/vatsal3003/viswals/internal/broker"
 	"github.com/vatsal3003/viswals/internal/consts"
-	"github.com/vatsal3003/viswals/internal/database"
-	"github.com/vatsal3003/viswals/internal/encryption"
-	"github.com/vatsal3003/viswals/internal/service/userservice"
-	"github.com/vatsal3003/viswals/internal/utils"
-	"github.com/vatsal3003/viswals/models"
 	"go.uber.org/zap"
 )
 
+const (
+	// minReconnectDelay and maxReconnectDelay bound the backoff between reconnection attempts
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+
+	// republishTimeout bounds the wait for the confirmation of a message moved to retry queue or dead letter exchange
+	republishTimeout = 30 * time.Second
+)
+
+var (
+	// ErrClosed is returned once the resources are closed
+	ErrClosed = broker.ErrClosed
+	// ErrNotReady is returned while the connection is lost
+	ErrNotReady = errors.New("rabbitmq is not connected")
+	// ErrNotConfirmed is returned when a republished message is nacked by rabbitmq
+	ErrNotConfirmed = errors.New("message is not confirmed by rabbitmq")
+	// ErrQueueUpgrade is returned when the queue is declared by an older version without dead letter exchange argument
+	ErrQueueUpgrade = errors.New("rabbitmq queue is declared by an older version, it has to be drained and deleted once")
+)
+
+// RabbitMQ is the broker backed by a RabbitMQ queue
 type RabbitMQ struct {
-	queue   amqp.Queue
+	logger    *zap.Logger
+	opts      *Options
+	queueName string
+
+	// mu guards the connection, it is replaced by supervisor on reconnection
+	mu      sync.RWMutex
 	channel *amqp.Channel
 	conn    *amqp.Connection
+	// confirmChannel is the channel which is put in confirm mode
+	confirmChannel *amqp.Channel
+	// connected is closed when connection is ready, it is replaced by a new one when connection is lost
+	connected chan struct{}
+
+	// retryQueues are the retry queues declared since connection
+	retryQueues map[time.Duration]bool
+
+	ready      atomic.Bool
+	reconnects atomic.Int64
+	closed     chan struct{}
+	closeOnce  sync.Once
 }
 
 type Options struct {
@@ -33,198 +65,305 @@ type Options struct {
 	AutoDelete bool
 	Exclusive  bool
 	NoWait     bool
+
+	// DeadLetterExchange and DeadLetterQueue receive the messages which are failed to process
+	// they default to the queue name with .dlx and .dlq suffix
+	DeadLetterExchange string
+	DeadLetterQueue    string
 }
 
+// New will connect with rabbitmq and declare the queue topology
+// the connection is supervised, so it is reconnected and topology is declared again when it is lost
 func New(logger *zap.Logger, opts *Options) (*RabbitMQ, error) {
-	var rabbitmq = &RabbitMQ{}
-	var err error
+	var rabbitmq = &RabbitMQ{
+		logger:    logger,
+		opts:      opts,
+		queueName: os.Getenv(consts.RabbitMQQueueName),
+		connected: make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+
+	if opts.DeadLetterExchange == "" {
+		opts.DeadLetterExchange = rabbitmq.queueName + ".dlx"
+	}
+	if opts.DeadLetterQueue == "" {
+		opts.DeadLetterQueue = rabbitmq.queueName + ".dlq"
+	}
 
-	rabbitmq.conn, err = amqp.Dial(os.Getenv(consts.RabbitMQConnURL))
+	connClose, channelClose, err := rabbitmq.connect()
 	if err != nil {
-		logger.Error("failed to connect with rabbitmq:" + err.Error())
 		return nil, err
 	}
 
-	rabbitmq.channel, err = rabbitmq.conn.Channel()
+	go rabbitmq.supervise(connClose, channelClose)
+
+	return rabbitmq, nil
+}
+
+// connect will open the connection and channel and declare the queue topology
+// it returns the channels notified when the connection or channel is closed
+func (rmq *RabbitMQ) connect() (chan *amqp.Error, chan *amqp.Error, error) {
+	conn, err := amqp.Dial(os.Getenv(consts.RabbitMQConnURL))
 	if err != nil {
-		logger.Error("failed to open rabbitmq connection channel:" + err.Error())
-		return nil, err
+		rmq.logger.Error("failed to connect with rabbitmq:" + err.Error())
+		return nil, nil, err
 	}
 
-	rabbitmq.queue, err = rabbitmq.channel.QueueDeclare(
-		os.Getenv(consts.RabbitMQQueueName), // name
-		opts.Durable,                        // durable
-		opts.AutoDelete,                     // delete when unused
-		opts.Exclusive,                      // exclusive
-		opts.NoWait,                         // no-wait
-		opts.Arguments,                      // arguments
-	)
+	channel, err := conn.Channel()
 	if err != nil {
-		logger.Error("failed to declare a queue from connection channel:" + err.Error())
-		return nil, err
+		rmq.logger.Error("failed to open rabbitmq connection channel:" + err.Error())
+		conn.Close()
+		return nil, nil, err
 	}
 
-	return rabbitmq, nil
-}
+	err = rmq.declare(channel)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
 
-func (rmq *RabbitMQ) Publish(logger *zap.Logger, csvReader *csv.Reader) error {
-	// buf is used to hold gob encoded user data
-	var buf bytes.Buffer
-	user := &models.User{}
+	connClose := conn.NotifyClose(make(chan *amqp.Error, 1))
+	channelClose := channel.NotifyClose(make(chan *amqp.Error, 1))
 
-	for {
-		encoder := gob.NewEncoder(&buf)
-		row, err := csvReader.Read()
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			} else if errors.Is(err, csv.ErrFieldCount) {
-				continue // continue if the row has partial data
-			}
-		}
+	rmq.mu.Lock()
+	rmq.conn = conn
+	rmq.channel = channel
+	rmq.confirmChannel = nil
+	rmq.retryQueues = make(map[time.Duration]bool)
+	close(rmq.connected)
+	rmq.mu.Unlock()
 
-		id, _ := strconv.Atoi(row[0])
-		puid, _ := strconv.Atoi(row[7])
-		if puid != -1 {
-			user.ParentUserID = &puid
-		} else {
-			user.ParentUserID = nil
-		}
+	rmq.ready.Store(true)
 
-		user.ID = id
-		user.CreatedAt = *utils.MillisToTime(row[4])
-		user.DeletedAt = utils.MillisToTime(row[5])
-		user.MergedAt = utils.MillisToTime(row[6])
-		user.FirstName = row[1]
-		user.LastName = row[2]
-		user.EmailAddress = row[3]
-
-		err = encoder.Encode(user)
-		if err != nil {
-			logger.Error("failed to encode user data into gob stream:" + err.Error())
-			return err
-		}
+	return connClose, channelClose, nil
+}
 
-		err = rmq.channel.PublishWithContext(
-			context.Background(), // context
-			"",                   // exchange
-			rmq.queue.Name,       // key
-			false,                // mandatory
-			false,                // immediate
-			amqp.Publishing{
-				ContentType: consts.ContentTypeGob,
-				Body:        buf.Bytes(),
-			}, // message
-		)
-		if err != nil {
-			logger.Error("failed to publish message:" + err.Error())
-			return err
-		}
+// declare will declare the dead letter and main queues, retry queues are declared when they are used
+func (rmq *RabbitMQ) declare(channel *amqp.Channel) error {
+	err := rmq.declareDeadLetter(channel)
+	if err != nil {
+		rmq.logger.Error("failed to declare dead letter exchange and queue:" + err.Error())
+		return err
+	}
 
-		buf.Reset()
+	// Rejected messages of queue are dead lettered by broker as well
+	arguments := amqp.Table{}
+	for key, value := range rmq.opts.Arguments {
+		arguments[key] = value
+	}
+	arguments["x-dead-letter-exchange"] = rmq.opts.DeadLetterExchange
+
+	_, err = channel.QueueDeclare(
+		rmq.queueName,       // name
+		rmq.opts.Durable,    // durable
+		rmq.opts.AutoDelete, // delete when unused
+		rmq.opts.Exclusive,  // exclusive
+		rmq.opts.NoWait,     // no-wait
+		arguments,           // arguments
+	)
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed {
+		// Arguments of an existing queue can not be changed, so the queue of an older version is rejected on every declare
+		rmq.logger.Error("failed to declare a queue, it exists with different arguments:"+err.Error(),
+			zap.String("queue", rmq.queueName),
+			zap.String("migration", "stop the producer, let the running consumer drain the queue, stop it, "+
+				"delete the queue with `rabbitmqctl delete_queue "+rmq.queueName+"` and start the new version"))
+		return errors.Join(ErrQueueUpgrade, err)
+	}
+	if err != nil {
+		rmq.logger.Error("failed to declare a queue from connection channel:" + err.Error())
+		return err
 	}
 
 	return nil
 }
 
-func (rmq *RabbitMQ) Consume(logger *zap.Logger, db *database.Database, usersChan chan []byte) {
-	messages, err := rmq.channel.ConsumeWithContext(
-		context.Background(), // context
-		rmq.queue.Name,       // queue
-		"",                   // consumer
-		true,                 // auto acknowledge
-		false,                // exclusive
-		false,                // no-local
-		false,                // no-wait
-		nil,                  // args
+// declareDeadLetter will declare the dead letter exchange and bind the dead letter queue to it
+func (rmq *RabbitMQ) declareDeadLetter(channel *amqp.Channel) error {
+	err := channel.ExchangeDeclare(
+		rmq.opts.DeadLetterExchange, // name
+		amqp.ExchangeFanout,         // kind
+		true,                        // durable
+		false,                       // auto delete
+		false,                       // internal
+		rmq.opts.NoWait,             // no-wait
+		nil,                         // arguments
 	)
 	if err != nil {
-		logger.Error("failed to consume gob stream")
+		return err
 	}
 
-	var forever = make(chan struct{})
+	_, err = channel.QueueDeclare(
+		rmq.opts.DeadLetterQueue, // name
+		true,                     // durable
+		false,                    // delete when unused
+		false,                    // exclusive
+		rmq.opts.NoWait,          // no-wait
+		nil,                      // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	return channel.QueueBind(
+		rmq.opts.DeadLetterQueue,    // name
+		"",                          // key
+		rmq.opts.DeadLetterExchange, // exchange
+		rmq.opts.NoWait,             // no-wait
+		nil,                         // arguments
+	)
+}
 
-	var errChan = make(chan error)
+// supervise will watch the connection and channel and reconnect with backoff when any of them is closed
+func (rmq *RabbitMQ) supervise(connClose, channelClose chan *amqp.Error) {
+	for {
+		var reason *amqp.Error
+		select {
+		case <-rmq.closed:
+			return
+		case reason = <-connClose:
+		case reason = <-channelClose:
+		}
 
-	go func(db *database.Database, usersChan chan []byte, errChan chan error) {
-		for msg := range usersChan {
-			var buf bytes.Buffer
-			encoder := gob.NewEncoder(&buf)
+		rmq.disconnect()
 
-			decoder := gob.NewDecoder(bytes.NewReader(msg))
+		select {
+		case <-rmq.closed:
+			return
+		default:
+		}
 
-			var user models.User
-			err := decoder.Decode(&user)
-			if err != nil {
-				logger.Error("failed to decode user from gob stream in consumer:" + err.Error())
-				errChan <- err
-				return
-			}
+		if reason != nil {
+			rmq.logger.Warn("rabbitmq connection lost, reconnecting:" + reason.Error())
+		} else {
+			rmq.logger.Warn("rabbitmq connection closed, reconnecting")
+		}
 
-			user.EmailAddress, err = encryption.Encrypt(user.EmailAddress)
-			if err != nil {
-				logger.Error("failed to encrypt the user email address:" + err.Error())
-				errChan <- err
+		delay := minReconnectDelay
+		for {
+			select {
+			case <-rmq.closed:
 				return
+			case <-time.After(delay):
 			}
 
-			err = encoder.Encode(user)
-			if err != nil {
-				errChan <- err
-				return
+			var err error
+			connClose, channelClose, err = rmq.connect()
+			if err == nil {
+				break
 			}
 
-			// Insert into database
-			go func(errChan chan error, user models.User) {
-				err := userservice.InsertUser(db, &user)
-				if err != nil {
-					logger.Error("failed to insert user into database:" + err.Error())
-					errChan <- err
-					return
-				}
-			}(errChan, user)
-			// Insert into cache
-			go func(errChan chan error, userID int, buf []byte) {
-				err := userservice.InsertUserInKVStore(db, userID, buf)
-				if err != nil {
-					logger.Error("failed to insert user into cache:" + err.Error())
-					errChan <- err
-					return
-				}
-			}(errChan, user.ID, buf.Bytes())
-
-			buf.Reset()
+			delay = min(2*delay, maxReconnectDelay)
+			rmq.logger.Warn("failed to reconnect with rabbitmq", zap.Duration("retry_in", delay))
 		}
-	}(db, usersChan, errChan)
 
-	go func(usersChan chan []byte) {
-		for message := range messages {
-			if message.ContentType != consts.ContentTypeGob {
-				logger.Error("invalid content-type, expected application/x-gob")
-				errChan <- err
-			}
+		reconnects := rmq.reconnects.Add(1)
+		rmq.logger.Info("reconnected with rabbitmq", zap.Int64("reconnects", reconnects))
+	}
+}
 
-			usersChan <- message.Body
-		}
-	}(usersChan)
+// disconnect will mark the connection as not ready and close what is left of it
+func (rmq *RabbitMQ) disconnect() {
+	rmq.ready.Store(false)
 
-	select {
-	case err := <-errChan:
-		logger.Error("failed to consume message:" + err.Error())
-		return
-	case <-forever: // blocking this function forever
+	rmq.mu.Lock()
+	conn := rmq.conn
+	rmq.connected = make(chan struct{})
+	rmq.mu.Unlock()
+
+	if conn != nil && !conn.IsClosed() {
+		_ = conn.Close()
 	}
 }
 
-func (rmq *RabbitMQ) CloseResources() {
-	err := rmq.channel.Close()
+// Ready will report whether the connection with rabbitmq is open
+func (rmq *RabbitMQ) Ready() bool {
+	return rmq.ready.Load()
+}
+
+// Reconnects will return the number of reconnections since start
+func (rmq *RabbitMQ) Reconnects() int64 {
+	return rmq.reconnects.Load()
+}
+
+// QueueDepth will return the number of ready messages in queue, it fails while the connection is not ready
+// the queue is inspected on its own channel, as a failed inspection closes the channel it runs on
+func (rmq *RabbitMQ) QueueDepth() (int, error) {
+	if !rmq.Ready() {
+		return 0, ErrNotReady
+	}
+
+	rmq.mu.RLock()
+	conn := rmq.conn
+	rmq.mu.RUnlock()
+
+	channel, err := conn.Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer channel.Close()
+
+	queue, err := channel.QueueDeclarePassive(
+		rmq.queueName,       // name
+		rmq.opts.Durable,    // durable
+		rmq.opts.AutoDelete, // delete when unused
+		rmq.opts.Exclusive,  // exclusive
+		false,               // no-wait
+		nil,                 // arguments
+	)
 	if err != nil {
+		return 0, err
+	}
+
+	return queue.Messages, nil
+}
+
+// currentChannel will wait until the connection is ready and return its channel
+func (rmq *RabbitMQ) currentChannel(ctx context.Context) (*amqp.Channel, error) {
+	for {
+		rmq.mu.RLock()
+		channel, connected := rmq.channel, rmq.connected
+		rmq.mu.RUnlock()
+
+		select {
+		case <-connected:
+			if !channel.IsClosed() {
+				return channel, nil
+			}
+			// Channel is closed but supervisor has not noticed it yet
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-rmq.closed:
+				return nil, ErrClosed
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		case <-rmq.closed:
+			return nil, ErrClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (rmq *RabbitMQ) CloseResources() {
+	rmq.closeOnce.Do(func() {
+		close(rmq.closed)
+	})
+	rmq.ready.Store(false)
+
+	rmq.mu.RLock()
+	channel, conn := rmq.channel, rmq.conn
+	rmq.mu.RUnlock()
+
+	err := channel.Close()
+	if err != nil && !errors.Is(err, amqp.ErrClosed) {
 		log.Println("ERROR failed to close rabbitmq channel:" + err.Error())
 		return
 	}
 
-	err = rmq.conn.Close()
-	if err != nil {
+	err = conn.Close()
+	if err != nil && !errors.Is(err, amqp.ErrClosed) {
 		log.Println("ERROR failed to close rabbitmq connection:" + err.Error())
 		return
 	}
@@ -0,0 +1,44 @@
+package rabbitmq
+
+import (
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// retryQueueName is named by delay, so changing the backoff does not redeclare a queue with different ttl
+func retryQueueName(queueName string, delay time.Duration) string {
+	return queueName + ".retry." + delay.String()
+}
+
+// declareRetryQueue will declare the delay queue of the delay once per connection and return its name
+// messages expire in delay queue after the delay and are dead lettered back to the queue
+func (rmq *RabbitMQ) declareRetryQueue(channel *amqp.Channel, delay time.Duration) (string, error) {
+	name := retryQueueName(rmq.queueName, delay)
+
+	rmq.mu.Lock()
+	defer rmq.mu.Unlock()
+
+	if rmq.retryQueues[delay] {
+		return name, nil
+	}
+
+	_, err := channel.QueueDeclare(
+		name,            // name
+		true,            // durable
+		false,           // delete when unused
+		false,           // exclusive
+		rmq.opts.NoWait, // no-wait
+		amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": rmq.queueName,
+		}, // arguments
+	)
+	if err != nil {
+		return "", err
+	}
+	rmq.retryQueues[delay] = true
+
+	return name, nil
+}
@@ -0,0 +1,98 @@
+package userservice
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/vatsal3003/viswals/models"
+)
+
+// ConflictPolicy decides what happens when an inserted user already exists
+type ConflictPolicy string
+
+const (
+	// ConflictIgnore keeps the existing user
+	ConflictIgnore ConflictPolicy = "ignore"
+	// ConflictOverwrite replaces the existing user with the inserted one
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictNewer replaces the existing user only when the latest of created_at, deleted_at and merged_at
+	// of the inserted user is after the one of existing user
+	ConflictNewer ConflictPolicy = "newer"
+)
+
+// DefaultConflictPolicy is used when no conflict policy is configured, existing users were always kept before the policy was configurable
+const DefaultConflictPolicy = ConflictIgnore
+
+// ParseConflictPolicy will parse the conflict policy, empty uses DefaultConflictPolicy
+func ParseConflictPolicy(policy string) (ConflictPolicy, error) {
+	switch ConflictPolicy(strings.ToLower(strings.TrimSpace(policy))) {
+	case "":
+		return DefaultConflictPolicy, nil
+	case ConflictIgnore:
+		return ConflictIgnore, nil
+	case ConflictOverwrite:
+		return ConflictOverwrite, nil
+	case ConflictNewer:
+		return ConflictNewer, nil
+	default:
+		return "", errors.New("invalid conflict policy " + policy + ", expected ignore, overwrite or newer")
+	}
+}
+
+// onConflict will return the conflict clause of insert statement for the policy in the dialect
+// inserted or updated rows are returned by the statement, so the rows kept as they are can be told apart
+func (policy ConflictPolicy) onConflict(d dialect) string {
+	const update = ` ON CONFLICT (id) DO UPDATE SET
+		first_name = EXCLUDED.first_name,
+		last_name = EXCLUDED.last_name,
+		email_address = EXCLUDED.email_address,
+		created_at = EXCLUDED.created_at,
+		deleted_at = EXCLUDED.deleted_at,
+		merged_at = EXCLUDED.merged_at,
+		parent_user_id = EXCLUDED.parent_user_id`
+
+	switch policy {
+	case ConflictOverwrite:
+		return update + " RETURNING id"
+	case ConflictNewer:
+		return update + `
+		WHERE ` + d.latest("EXCLUDED") + " > " + d.latest("users") + " RETURNING id"
+	default:
+		return " ON CONFLICT (id) DO NOTHING RETURNING id"
+	}
+}
+
+// dedupe will keep one user for every id, as a single statement can not update a row twice
+// the last user wins, unless the policy keeps the newer one
+func (policy ConflictPolicy) dedupe(users []*models.User) []*models.User {
+	index := make(map[int]int, len(users))
+	deduped := make([]*models.User, 0, len(users))
+
+	for _, user := range users {
+		i, ok := index[user.ID]
+		if !ok {
+			index[user.ID] = len(deduped)
+			deduped = append(deduped, user)
+			continue
+		}
+
+		if policy == ConflictNewer && latestChange(user).Before(latestChange(deduped[i])) {
+			continue
+		}
+		deduped[i] = user
+	}
+
+	return deduped
+}
+
+// latestChange will return the latest of created_at, deleted_at and merged_at
+func latestChange(user *models.User) time.Time {
+	latest := user.CreatedAt
+	for _, at := range []*time.Time{user.DeletedAt, user.MergedAt} {
+		if at != nil && at.After(latest) {
+			latest = *at
+		}
+	}
+	return latest
+}
@@ -0,0 +1,86 @@
+package userservice
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+)
+
+// Kinds of the errors of user service, every error returned by the service functions matches at most one of them with errors.Is
+var (
+	// ErrNotFound is the kind of errors of missing users
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidInput is the kind of errors of invalid users, ids, filters and cursors, sending them again fails as well
+	ErrInvalidInput = errors.New("invalid input")
+	// ErrConflict is the kind of errors of writes which conflict with the stored users
+	ErrConflict = errors.New("conflict")
+	// ErrUnavailable is the kind of errors of unreachable database, the request can be tried again later
+	ErrUnavailable = errors.New("database is unavailable")
+)
+
+// Error is the error of user service, it matches its Kind and its Message can be shown to the clients
+type Error struct {
+	Kind    error
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+var (
+	// ErrUserNotFound is returned when the user does not exist
+	ErrUserNotFound = &Error{Kind: ErrNotFound, Message: "user does not exist"}
+	// ErrInvalidUser is returned when the user violates a constraint of users table, so storing it again fails as well
+	ErrInvalidUser = &Error{Kind: ErrInvalidInput, Message: "user violates a constraint"}
+	// ErrMissingParent is returned when the parent user does not exist, the user can be stored once its parent is stored
+	ErrMissingParent = &Error{Kind: ErrInvalidInput, Message: "parent user does not exist"}
+	// ErrUserExists is returned when a created user has the id of an existing user
+	ErrUserExists = &Error{Kind: ErrConflict, Message: "user already exists"}
+	// ErrMergeCycle is returned when a merge would make a user merged into itself, or the merge chain is already a cycle
+	ErrMergeCycle = &Error{Kind: ErrConflict, Message: "merge would create a cycle"}
+	// ErrParentMerged is returned when the parent of updated user is merged into the user
+	ErrParentMerged = &Error{Kind: ErrInvalidInput, Message: "parent user is merged into the user"}
+	// ErrAlreadyMerged is returned when a user which is merged into another user is merged again
+	ErrAlreadyMerged = &Error{Kind: ErrConflict, Message: "user is already merged into another user"}
+	// ErrInvalidCursor is returned when the page cursor is malformed or was issued for another sort
+	ErrInvalidCursor = &Error{Kind: ErrInvalidInput, Message: "invalid cursor"}
+)
+
+// databaseError is the database error with the error of user service it causes, it matches both of them
+type databaseError struct {
+	kind error
+	err  error
+}
+
+func (e *databaseError) Error() string {
+	return e.kind.Error() + ": " + e.err.Error()
+}
+
+func (e *databaseError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// serviceError will give the error of repository its kind, so the callers can tell a missing user from an outage
+func serviceError(err error) error {
+	var netErr net.Error
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrInvalidInput), errors.Is(err, ErrConflict), errors.Is(err, ErrUnavailable):
+		return err
+	case errors.Is(err, sql.ErrNoRows):
+		return &databaseError{kind: ErrUserNotFound, err: err}
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone), errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr):
+		return &databaseError{kind: ErrUnavailable, err: err}
+	default:
+		return err
+	}
+}
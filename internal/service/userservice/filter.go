@@ -0,0 +1,233 @@
+package userservice
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vatsal3003/viswals/models"
+)
+
+// Match decides how the names of filter are matched, names are always matched case insensitive
+type Match string
+
+const (
+	// MatchExact matches the whole name
+	MatchExact Match = "exact"
+	// MatchPrefix matches the names starting with the value
+	MatchPrefix Match = "prefix"
+	// MatchContains matches the names containing the value
+	MatchContains Match = "contains"
+)
+
+// ParseMatch will parse the match, empty matches by prefix
+func ParseMatch(match string) (Match, error) {
+	switch Match(strings.ToLower(strings.TrimSpace(match))) {
+	case "", MatchPrefix:
+		return MatchPrefix, nil
+	case MatchExact:
+		return MatchExact, nil
+	case MatchContains:
+		return MatchContains, nil
+	default:
+		return "", errors.New("invalid match " + match + ", expected exact, prefix or contains")
+	}
+}
+
+// Combine decides whether a user has to match all conditions of filter or any of them
+type Combine string
+
+const (
+	// CombineAnd matches the users matching all conditions
+	CombineAnd Combine = "and"
+	// CombineOr matches the users matching any condition
+	CombineOr Combine = "or"
+)
+
+// ParseCombine will parse the combine, empty combines with and
+func ParseCombine(combine string) (Combine, error) {
+	switch Combine(strings.ToLower(strings.TrimSpace(combine))) {
+	case "", CombineAnd:
+		return CombineAnd, nil
+	case CombineOr:
+		return CombineOr, nil
+	default:
+		return "", errors.New("invalid combine " + combine + ", expected and or or")
+	}
+}
+
+// UserFilter matches the users by the set conditions combined by Combine, empty filter matches every user
+type UserFilter struct {
+	// FirstName and LastName are matched by Match, wildcards are matched as they are
+	FirstName string
+	LastName  string
+	Match     Match
+
+	// IDs matches the users with any of the ids
+	IDs []int
+	// CreatedFrom matches the users created at or after it and CreatedBefore the ones created before it
+	CreatedFrom   *time.Time
+	CreatedBefore *time.Time
+	// Deleted and Merged match the users whose deleted_at and merged_at is set when true and not set when false
+	Deleted *bool
+	Merged  *bool
+	// ParentUserID matches the users merged into the parent
+	ParentUserID *int
+
+	Combine Combine
+}
+
+// query builds the parameterized sql of filter and page, values are always passed as arguments
+type query struct {
+	d dialect
+	// conditions are combined with AND
+	conditions []string
+	args       []any
+}
+
+// arg will add the argument and return its placeholder
+func (q *query) arg(value any) string {
+	q.args = append(q.args, value)
+	return q.d.placeholder(len(q.args))
+}
+
+// where will return the where clause of the conditions
+func (q *query) where() string {
+	if len(q.conditions) == 0 {
+		return ""
+	}
+	return " WHERE (" + strings.Join(q.conditions, ") AND (") + ")"
+}
+
+// like will return the condition matching the column by the pattern, value is escaped and wrapped by the wildcards of match
+func (q *query) like(column, value string, match Match) string {
+	pattern := escapeLike(value)
+	switch match {
+	case MatchContains:
+		pattern = "%" + pattern + "%"
+	case MatchExact:
+	default:
+		pattern += "%"
+	}
+	return column + " " + q.d.ilike + " " + q.arg(pattern) + ` ESCAPE '\'`
+}
+
+// present will return the condition matching the column by whether it is set
+func present(column string, present bool) string {
+	if present {
+		return column + " IS NOT NULL"
+	}
+	return column + " IS NULL"
+}
+
+// where will add the conditions of filter combined by Combine to the query
+func (filter UserFilter) where(q *query) {
+	var conditions []string
+
+	if filter.FirstName != "" {
+		conditions = append(conditions, q.like("first_name", filter.FirstName, filter.Match))
+	}
+	if filter.LastName != "" {
+		conditions = append(conditions, q.like("last_name", filter.LastName, filter.Match))
+	}
+
+	if len(filter.IDs) != 0 {
+		placeholders := make([]string, len(filter.IDs))
+		for i, id := range filter.IDs {
+			placeholders[i] = q.arg(id)
+		}
+		conditions = append(conditions, "id IN ("+strings.Join(placeholders, ", ")+")")
+	}
+
+	// Timestamps are compared in UTC, as sqlite stores them in UTC text
+	if filter.CreatedFrom != nil {
+		conditions = append(conditions, "created_at >= "+q.arg(filter.CreatedFrom.UTC()))
+	}
+	if filter.CreatedBefore != nil {
+		conditions = append(conditions, "created_at < "+q.arg(filter.CreatedBefore.UTC()))
+	}
+
+	if filter.Deleted != nil {
+		conditions = append(conditions, present("deleted_at", *filter.Deleted))
+	}
+	if filter.Merged != nil {
+		conditions = append(conditions, present("merged_at", *filter.Merged))
+	}
+
+	if filter.ParentUserID != nil {
+		conditions = append(conditions, "parent_user_id = "+q.arg(*filter.ParentUserID))
+	}
+
+	if len(conditions) == 0 {
+		return
+	}
+
+	operator := ") AND ("
+	if filter.Combine == CombineOr {
+		operator = ") OR ("
+	}
+
+	q.conditions = append(q.conditions, "("+strings.Join(conditions, operator)+")")
+}
+
+// Matches will report whether the user matches the filter, it matches the users like the filter query of repository
+// and is used for the users which are not read from database, e.g. the users streamed by hub
+func (filter UserFilter) Matches(user *models.User) bool {
+	var conditions []bool
+
+	if filter.FirstName != "" {
+		conditions = append(conditions, matchName(user.FirstName, filter.FirstName, filter.Match))
+	}
+	if filter.LastName != "" {
+		conditions = append(conditions, matchName(user.LastName, filter.LastName, filter.Match))
+	}
+
+	if len(filter.IDs) != 0 {
+		conditions = append(conditions, slices.Contains(filter.IDs, user.ID))
+	}
+
+	if filter.CreatedFrom != nil {
+		conditions = append(conditions, !user.CreatedAt.Before(*filter.CreatedFrom))
+	}
+	if filter.CreatedBefore != nil {
+		conditions = append(conditions, user.CreatedAt.Before(*filter.CreatedBefore))
+	}
+
+	if filter.Deleted != nil {
+		conditions = append(conditions, (user.DeletedAt != nil) == *filter.Deleted)
+	}
+	if filter.Merged != nil {
+		conditions = append(conditions, (user.MergedAt != nil) == *filter.Merged)
+	}
+
+	if filter.ParentUserID != nil {
+		conditions = append(conditions, user.ParentUserID != nil && *user.ParentUserID == *filter.ParentUserID)
+	}
+
+	if len(conditions) == 0 {
+		return true
+	}
+	if filter.Combine == CombineOr {
+		return slices.Contains(conditions, true)
+	}
+	return !slices.Contains(conditions, false)
+}
+
+// matchName will match the name by the value case insensitive, like the like condition of query
+func matchName(name, value string, match Match) bool {
+	name, value = strings.ToLower(name), strings.ToLower(value)
+	switch match {
+	case MatchContains:
+		return strings.Contains(name, value)
+	case MatchExact:
+		return name == value
+	default:
+		return strings.HasPrefix(name, value)
+	}
+}
+
+// escapeLike will escape the wildcards of like pattern, so the value is matched as it is
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
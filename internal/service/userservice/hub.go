@@ -0,0 +1,138 @@
+package userservice
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vatsal3003/viswals/models"
+)
+
+const (
+	// DefaultHubSize is the number of latest events kept by hub for the reconnecting subscribers
+	DefaultHubSize = 1000
+	// subscriptionBuffer is the number of events a subscriber can fall behind before it is dropped
+	subscriptionBuffer = 256
+)
+
+// Event is the stored user sent to the subscribers of hub
+// its ID is the epoch of hub followed by the sequence of event, e.g. "m1x2c3d4-42", so the ids of a restarted hub are told apart
+type Event struct {
+	ID   string
+	User *models.User
+}
+
+// Hub broadcasts the stored users to its subscribers in process, it keeps the latest events,
+// so a subscriber can resume from the last event it received
+type Hub struct {
+	mu            sync.Mutex
+	epoch         string
+	seq           uint64
+	events        []Event
+	subscriptions map[*Subscription]struct{}
+}
+
+// Subscription receives the events of hub published after it is subscribed
+type Subscription struct {
+	events chan Event
+}
+
+// Events will return the events of subscription, it is closed when the subscriber falls behind or is unsubscribed
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// NewHub will create the hub which keeps the latest size events, DefaultHubSize is used when size is not positive
+func NewHub(size int) *Hub {
+	if size <= 0 {
+		size = DefaultHubSize
+	}
+	return &Hub{
+		epoch:         strconv.FormatInt(time.Now().UnixNano(), 36),
+		events:        make([]Event, size),
+		subscriptions: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish will send the users to every subscriber without blocking, subscribers whose buffer is full are dropped,
+// so a slow client never holds back the pipeline, it can resume from its last event instead
+func (h *Hub) Publish(users ...*models.User) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, user := range users {
+		h.seq++
+		event := Event{ID: h.epoch + "-" + strconv.FormatUint(h.seq, 10), User: user}
+		h.events[h.seq%uint64(len(h.events))] = event
+
+		for subscription := range h.subscriptions {
+			select {
+			case subscription.events <- event:
+			default:
+				h.drop(subscription)
+			}
+		}
+	}
+}
+
+// Subscribe will subscribe to the events published from now on and return the kept events published after lastEventID
+// events of another epoch, e.g. before a restart, can not be resumed, so every kept event is returned for them,
+// and none is returned when lastEventID is empty or malformed
+func (h *Hub) Subscribe(lastEventID string) (*Subscription, []Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	subscription := &Subscription{events: make(chan Event, subscriptionBuffer)}
+	h.subscriptions[subscription] = struct{}{}
+
+	return subscription, h.after(lastEventID)
+}
+
+// Unsubscribe will stop sending the events to the subscription and close it
+func (h *Hub) Unsubscribe(subscription *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.drop(subscription)
+}
+
+// drop will remove the subscription and close its events, it is called with the lock held
+func (h *Hub) drop(subscription *Subscription) {
+	if _, ok := h.subscriptions[subscription]; ok {
+		delete(h.subscriptions, subscription)
+		close(subscription.events)
+	}
+}
+
+// after will return the kept events published after the event, it is called with the lock held
+func (h *Hub) after(lastEventID string) []Event {
+	if lastEventID == "" {
+		return nil
+	}
+
+	size := uint64(len(h.events))
+	oldest := uint64(1)
+	if h.seq > size {
+		oldest = h.seq - size + 1
+	}
+
+	from := oldest
+	epoch, seq, ok := strings.Cut(lastEventID, "-")
+	if ok && epoch == h.epoch {
+		last, err := strconv.ParseUint(seq, 10, 64)
+		if err != nil {
+			return nil
+		}
+		// Events older than the kept ones are lost, the kept ones are still sent
+		from = max(last+1, oldest)
+	} else if !ok {
+		return nil
+	}
+
+	var events []Event
+	for seq := from; seq <= h.seq; seq++ {
+		events = append(events, h.events[seq%size])
+	}
+	return events
+}
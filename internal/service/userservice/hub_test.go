@@ -0,0 +1,73 @@
+package userservice_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vatsal3003/viswals/internal/service/userservice"
+	"github.com/vatsal3003/viswals/models"
+)
+
+func TestHub(t *testing.T) {
+	hub := userservice.NewHub(3)
+
+	first, missed := hub.Subscribe("")
+	assert.Empty(t, missed)
+
+	hub.Publish(&models.User{ID: 8}, &models.User{ID: 13})
+
+	var events []userservice.Event
+	for range 2 {
+		events = append(events, <-first.Events())
+	}
+	assert.Equal(t, 8, events[0].User.ID)
+	assert.Equal(t, 13, events[1].User.ID)
+	assert.NotEqual(t, events[0].ID, events[1].ID)
+
+	hub.Publish(&models.User{ID: 31}, &models.User{ID: 42})
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		want        []int
+	}{
+		{name: "New subscriber", lastEventID: "", want: nil},
+		{name: "Resumed subscriber", lastEventID: events[1].ID, want: []int{31, 42}},
+		{name: "Events older than the kept ones", lastEventID: events[0].ID, want: []int{13, 31, 42}},
+		{name: "Restarted hub", lastEventID: "epoch-2", want: []int{13, 31, 42}},
+		{name: "Malformed id", lastEventID: "42", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subscription, missed := hub.Subscribe(tt.lastEventID)
+			defer hub.Unsubscribe(subscription)
+
+			var got []int
+			for _, event := range missed {
+				got = append(got, event.User.ID)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	hub.Unsubscribe(first)
+	for range first.Events() {
+		// Drain the events published before unsubscribing, so the loop ends once the events are closed
+	}
+
+	t.Run("Slow subscriber is dropped", func(t *testing.T) {
+		slow, _ := hub.Subscribe("")
+
+		// The subscriber buffer is smaller than this
+		for i := range 1000 {
+			hub.Publish(&models.User{ID: i + 1})
+		}
+
+		received := 0
+		for range slow.Events() {
+			received++
+		}
+		assert.Less(t, received, 1000)
+	})
+}
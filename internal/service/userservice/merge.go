@@ -0,0 +1,134 @@
+package userservice
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/vatsal3003/viswals/models"
+)
+
+// maxMergeDepth is the longest merge chain which is followed, a longer chain can only be a cycle of ingested users
+const maxMergeDepth = 100
+
+// mergeChain will return the user followed by the users it is merged into, up to the canonical user
+// a parent which was never ingested ends the chain, as the parent of ingested users is not validated,
+// and so does a parent_user_id without merged_at, as the user is not merged yet
+func mergeChain(db querier, d dialect, userID int) ([]*models.User, error) {
+	q := &query{d: d}
+	users, err := queryUsers(db, `WITH RECURSIVE chain (user_id, depth) AS (
+		SELECT id, 0 FROM users WHERE id = `+q.arg(userID)+`
+		UNION ALL
+		SELECT users.parent_user_id, chain.depth + 1 FROM users JOIN chain ON users.id = chain.user_id
+		WHERE users.parent_user_id IS NOT NULL AND users.merged_at IS NOT NULL AND chain.depth < `+strconv.Itoa(maxMergeDepth)+`
+	) `+selectUsers+` JOIN chain ON id = chain.user_id ORDER BY chain.depth`, q.args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(users) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	seen := make(map[int]bool, len(users))
+	for _, user := range users {
+		if seen[user.ID] {
+			return nil, ErrMergeCycle
+		}
+		seen[user.ID] = true
+	}
+
+	return users, nil
+}
+
+// listChildren will return the users merged into the user directly or through other merged users,
+// users having parent_user_id without merged_at are not merged yet
+func listChildren(db querier, d dialect, userID int) ([]*models.User, error) {
+	q := &query{d: d}
+	return queryUsers(db, `WITH RECURSIVE children (user_id, depth) AS (
+		SELECT id, 1 FROM users WHERE parent_user_id = `+q.arg(userID)+` AND merged_at IS NOT NULL
+		UNION ALL
+		SELECT users.id, children.depth + 1 FROM users JOIN children ON users.parent_user_id = children.user_id
+		WHERE users.merged_at IS NOT NULL AND children.depth < `+strconv.Itoa(maxMergeDepth)+`
+	) `+selectUsers+` WHERE id IN (SELECT user_id FROM children) AND id <> `+q.arg(userID)+` ORDER BY id`, q.args...)
+}
+
+// mergeUser will merge the user into the parent user, the merges are serialized by the lock of dialect,
+// so two merges can not make a cycle together
+func mergeUser(db *sql.DB, d dialect, userID, parentUserID int, mergedAt time.Time) error {
+	if userID == parentUserID {
+		return ErrMergeCycle
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockMerges(tx, d)
+	if err != nil {
+		return err
+	}
+
+	// A user having parent_user_id without merged_at is not merged yet
+	q := &query{d: d}
+	var currentParentUserID *int
+	var currentMergedAt *time.Time
+	err = tx.QueryRow("SELECT parent_user_id, merged_at FROM users WHERE id = "+q.arg(userID), q.args...).Scan(&currentParentUserID, &currentMergedAt)
+	if err != nil {
+		return err
+	}
+	if currentMergedAt != nil && (currentParentUserID == nil || *currentParentUserID != parentUserID) {
+		return ErrAlreadyMerged
+	}
+
+	merged, err := mergedInto(tx, d, parentUserID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrMissingParent
+	}
+	if err != nil {
+		return err
+	}
+	if merged {
+		return ErrMergeCycle
+	}
+
+	// A user merged again into the same parent keeps its merged_at
+	q = &query{d: d}
+	_, err = tx.Exec("UPDATE users SET merged_at = COALESCE(merged_at, "+q.arg(mergedAt.UTC())+"), parent_user_id = "+q.arg(parentUserID)+
+		" WHERE id = "+q.arg(userID)+";", q.args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockMerges will take the lock of dialect until the end of transaction, so the merge chains are changed one at a time
+func lockMerges(tx *sql.Tx, d dialect) error {
+	if d.lockMerges == "" {
+		return nil
+	}
+
+	_, err := tx.Exec(d.lockMerges)
+	return err
+}
+
+// mergedInto will report whether the user is in the merge chain of the parent user,
+// sql.ErrNoRows is returned when the parent user does not exist
+func mergedInto(db querier, d dialect, parentUserID, userID int) (bool, error) {
+	chain, err := mergeChain(db, d, parentUserID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, user := range chain {
+		if user.ID == userID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,203 @@
+package userservice
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/vatsal3003/viswals/models"
+)
+
+const (
+	// DefaultPageLimit is the number of users in a page when no limit is set
+	DefaultPageLimit = 100
+	// MaxPageLimit is the largest number of users in a page
+	MaxPageLimit = 1000
+)
+
+// Sort is the column the users are sorted by, ties are sorted by id
+type Sort string
+
+const (
+	SortID        Sort = "id"
+	SortCreatedAt Sort = "created_at"
+	SortLastName  Sort = "last_name"
+)
+
+// ParseSort will parse the sort, empty sorts by id
+func ParseSort(sort string) (Sort, error) {
+	switch Sort(strings.ToLower(strings.TrimSpace(sort))) {
+	case "", SortID:
+		return SortID, nil
+	case SortCreatedAt:
+		return SortCreatedAt, nil
+	case SortLastName:
+		return SortLastName, nil
+	default:
+		return "", errors.New("invalid sort " + sort + ", expected id, created_at or last_name")
+	}
+}
+
+// Order is the direction of sort
+type Order string
+
+const (
+	OrderAsc  Order = "asc"
+	OrderDesc Order = "desc"
+)
+
+// ParseOrder will parse the order, empty sorts ascending
+func ParseOrder(order string) (Order, error) {
+	switch Order(strings.ToLower(strings.TrimSpace(order))) {
+	case "", OrderAsc:
+		return OrderAsc, nil
+	case OrderDesc:
+		return OrderDesc, nil
+	default:
+		return "", errors.New("invalid order " + order + ", expected asc or desc")
+	}
+}
+
+// Page selects the users after the cursor in the sort order, empty page is the first page of DefaultPageLimit users by id
+type Page struct {
+	// Limit is the number of users in page, it is capped by MaxPageLimit
+	Limit int
+	// Cursor is the next cursor of the previous page, empty cursor starts from the first user
+	Cursor string
+	Sort   Sort
+	Order  Order
+}
+
+func (page Page) limit() int {
+	if page.Limit <= 0 {
+		return DefaultPageLimit
+	}
+	return min(page.Limit, MaxPageLimit)
+}
+
+func (page Page) sort() Sort {
+	if page.Sort == "" {
+		return SortID
+	}
+	return page.Sort
+}
+
+func (page Page) order() Order {
+	if page.Order == "" {
+		return OrderAsc
+	}
+	return page.Order
+}
+
+// cursor is the position of the last user of page, it is encoded with the sort so it can not be used with another sort
+type cursor struct {
+	Sort      Sort       `json:"s"`
+	Order     Order      `json:"o"`
+	ID        int        `json:"i"`
+	CreatedAt *time.Time `json:"c,omitempty"`
+	LastName  *string    `json:"l,omitempty"`
+}
+
+// next will return the opaque cursor of the page after the user
+func (page Page) next(user *models.User) string {
+	c := cursor{Sort: page.sort(), Order: page.order(), ID: user.ID}
+	switch c.Sort {
+	case SortCreatedAt:
+		createdAt := user.CreatedAt.UTC()
+		c.CreatedAt = &createdAt
+	case SortLastName:
+		c.LastName = &user.LastName
+	}
+
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// after will add the condition selecting the users after the cursor to the query
+func (page Page) after(q *query) error {
+	if page.Cursor == "" {
+		return nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(page.Cursor)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+
+	var c cursor
+	err = json.Unmarshal(data, &c)
+	if err != nil || c.Sort != page.sort() || c.Order != page.order() {
+		return ErrInvalidCursor
+	}
+
+	operator := " > "
+	if c.Order == OrderDesc {
+		operator = " < "
+	}
+
+	// Row values compare the sort column first and the id on ties, as the sort column is not unique
+	switch {
+	case c.Sort == SortID:
+		q.conditions = append(q.conditions, "id"+operator+q.arg(c.ID))
+	case c.Sort == SortCreatedAt && c.CreatedAt != nil:
+		q.conditions = append(q.conditions, "(created_at, id)"+operator+"("+q.arg(c.CreatedAt.UTC())+", "+q.arg(c.ID)+")")
+	case c.Sort == SortLastName && c.LastName != nil:
+		q.conditions = append(q.conditions, "(last_name, id)"+operator+"("+q.arg(*c.LastName)+", "+q.arg(c.ID)+")")
+	default:
+		return ErrInvalidCursor
+	}
+
+	return nil
+}
+
+// orderBy will return the order by and limit clause of page, one more user than limit is selected to tell whether there is a next page
+func (page Page) orderBy(q *query) string {
+	direction := " ASC"
+	if page.order() == OrderDesc {
+		direction = " DESC"
+	}
+
+	orderBy := " ORDER BY id" + direction
+	if sort := page.sort(); sort != SortID {
+		orderBy = " ORDER BY " + string(sort) + direction + ", id" + direction
+	}
+
+	return orderBy + " LIMIT " + q.arg(page.limit()+1)
+}
+
+// listUsers will return the page of users matching the filter with the cursor of next page, which is empty on the last page
+func listUsers(db *sql.DB, d dialect, filter UserFilter, page Page) ([]*models.User, string, error) {
+	q := &query{d: d}
+	filter.where(q)
+
+	err := page.after(q)
+	if err != nil {
+		return nil, "", err
+	}
+
+	users, err := queryUsers(db, selectUsers+q.where()+page.orderBy(q), q.args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	limit := page.limit()
+	if len(users) <= limit {
+		return users, "", nil
+	}
+
+	users = users[:limit]
+	return users, page.next(users[limit-1]), nil
+}
+
+// countUsers will return the number of users matching the filter
+func countUsers(db *sql.DB, d dialect, filter UserFilter) (int, error) {
+	q := &query{d: d}
+	filter.where(q)
+
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM users"+q.where(), q.args...).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,235 @@
+package userservice
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/vatsal3003/viswals/models"
+)
+
+// mergeLockKey is the key of advisory lock which serializes the merges
+const mergeLockKey = 8413
+
+// postgresDialect uses numbered placeholders, GREATEST ignores the null timestamps, merges take an advisory lock
+var postgresDialect = dialect{
+	placeholder: func(position int) string {
+		return "$" + strconv.Itoa(position)
+	},
+	ilike: "ILIKE",
+	latest: func(table string) string {
+		return "GREATEST(" + table + ".created_at, " + table + ".deleted_at, " + table + ".merged_at)"
+	},
+	lockMerges: "SELECT pg_advisory_xact_lock(" + strconv.Itoa(mergeLockKey) + ");",
+}
+
+// PostgresRepository stores the users in postgres
+type PostgresRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresRepository will create the repository over postgres connection
+func NewPostgresRepository(db *sql.DB) *PostgresRepository {
+	return &PostgresRepository{db: db}
+}
+
+func (r *PostgresRepository) InsertUser(user *models.User, lineage models.UserLineage, policy ConflictPolicy) (_ bool, err error) {
+	defer func() { err = postgresError(err) }()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	written := true
+
+	var id int
+	err = tx.QueryRow("INSERT INTO users VALUES ($1, $2, $3, $4, $5, $6, $7, $8)"+policy.onConflict(postgresDialect)+";", user.ID, user.FirstName, user.LastName, user.EmailAddress, user.CreatedAt, user.DeletedAt, user.MergedAt, user.ParentUserID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		written = false
+	} else if err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec("INSERT INTO user_lineage (user_id, schema_version, source, line, batch_id, published_at) VALUES ($1, $2, $3, $4, $5, $6);", lineage.UserID, lineage.SchemaVersion, lineage.Source, lineage.Line, lineage.BatchID, publishedAt(lineage))
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return written, nil
+}
+
+// InsertUsers will copy the users into a staging table and move them into users table with a single statement
+// which resolves the conflicts by the policy
+func (r *PostgresRepository) InsertUsers(users []*models.User, lineages []models.UserLineage, policy ConflictPolicy) (_ []int, err error) {
+	defer func() { err = postgresError(err) }()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("CREATE TEMP TABLE users_staging (LIKE users) ON COMMIT DROP;")
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := tx.Prepare(pq.CopyIn("users_staging", "id", "first_name", "last_name", "email_address", "created_at", "deleted_at", "merged_at", "parent_user_id"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, user := range policy.dedupe(users) {
+		_, err = stmt.Exec(user.ID, user.FirstName, user.LastName, user.EmailAddress, user.CreatedAt, user.DeletedAt, user.MergedAt, user.ParentUserID)
+		if err != nil {
+			stmt.Close()
+			return nil, err
+		}
+	}
+
+	// Flush the copied rows
+	_, err = stmt.Exec()
+	if err != nil {
+		stmt.Close()
+		return nil, err
+	}
+
+	err = stmt.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query("INSERT INTO users SELECT * FROM users_staging" + policy.onConflict(postgresDialect) + ";")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var written []int
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		written = append(written, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = copyLineages(tx, lineages)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return written, nil
+}
+
+// postgresError will tell the constraint violations and the outages of postgres apart, the parent is checked at commit
+func postgresError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	switch pqErr.Code.Class() {
+	case "08", "53", "57": // connection_exception, insufficient_resources, operator_intervention
+		return &databaseError{kind: ErrUnavailable, err: err}
+	}
+
+	switch pqErr.Code {
+	case "23505": // unique_violation
+		return &databaseError{kind: ErrUserExists, err: err}
+	case "23503": // foreign_key_violation
+		return &databaseError{kind: ErrMissingParent, err: err}
+	case "23502", "23514": // not_null_violation, check_violation
+		return &databaseError{kind: ErrInvalidUser, err: err}
+	default:
+		return err
+	}
+}
+
+// copyLineages will copy the lineages into user_lineage table
+func copyLineages(tx *sql.Tx, lineages []models.UserLineage) error {
+	stmt, err := tx.Prepare(pq.CopyIn("user_lineage", "user_id", "schema_version", "source", "line", "batch_id", "published_at"))
+	if err != nil {
+		return err
+	}
+
+	for _, lineage := range lineages {
+		_, err = stmt.Exec(lineage.UserID, lineage.SchemaVersion, lineage.Source, lineage.Line, lineage.BatchID, publishedAt(lineage))
+		if err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+
+	_, err = stmt.Exec()
+	if err != nil {
+		stmt.Close()
+		return err
+	}
+
+	return stmt.Close()
+}
+
+// publishedAt is null for the messages published without the time, e.g. schema version 0
+func publishedAt(lineage models.UserLineage) sql.NullTime {
+	return sql.NullTime{Time: lineage.PublishedAt, Valid: !lineage.PublishedAt.IsZero()}
+}
+
+func (r *PostgresRepository) GetUser(userID int) (*models.User, error) {
+	user, err := scanUser(r.db.QueryRow(selectUsers+" WHERE id = $1", userID))
+	return user, postgresError(err)
+}
+
+func (r *PostgresRepository) ListUsers(filter UserFilter, page Page) ([]*models.User, string, error) {
+	users, next, err := listUsers(r.db, postgresDialect, filter, page)
+	return users, next, postgresError(err)
+}
+
+func (r *PostgresRepository) CountUsers(filter UserFilter) (int, error) {
+	count, err := countUsers(r.db, postgresDialect, filter)
+	return count, postgresError(err)
+}
+
+func (r *PostgresRepository) CreateUser(user *models.User) error {
+	return postgresError(createUser(r.db, postgresDialect, user))
+}
+
+func (r *PostgresRepository) UpdateUser(user *models.User) error {
+	return postgresError(updateUser(r.db, postgresDialect, user))
+}
+
+func (r *PostgresRepository) MergeUser(userID, parentUserID int, mergedAt time.Time) error {
+	return postgresError(mergeUser(r.db, postgresDialect, userID, parentUserID, mergedAt))
+}
+
+func (r *PostgresRepository) MergeChain(userID int) ([]*models.User, error) {
+	chain, err := mergeChain(r.db, postgresDialect, userID)
+	return chain, postgresError(err)
+}
+
+func (r *PostgresRepository) ListChildren(userID int) ([]*models.User, error) {
+	children, err := listChildren(r.db, postgresDialect, userID)
+	return children, postgresError(err)
+}
+
+func (r *PostgresRepository) DeleteUser(userID int, deletedAt time.Time) error {
+	return postgresError(deleteUser(r.db, postgresDialect, userID, deletedAt))
+}
@@ -0,0 +1,186 @@
+package userservice
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/vatsal3003/viswals/internal/database"
+	"github.com/vatsal3003/viswals/models"
+)
+
+// UserRepository stores the users with their lineage, users are kept with the encrypted email address
+type UserRepository interface {
+	// InsertUser will insert the user with its lineage and resolve the conflict with existing user by the policy,
+	// so ignore policy only inserts and the others upsert, it reports whether the user is written
+	InsertUser(user *models.User, lineage models.UserLineage, policy ConflictPolicy) (bool, error)
+	// InsertUsers will insert the users with their lineage in one transaction and resolve the conflicts by the policy,
+	// it returns the ids of written users
+	InsertUsers(users []*models.User, lineages []models.UserLineage, policy ConflictPolicy) ([]int, error)
+	// GetUser will return the user by id, sql.ErrNoRows is returned when the user does not exist
+	GetUser(userID int) (*models.User, error)
+	// ListUsers will return the page of users matching the filter with the cursor of next page, which is empty on the last page
+	// ErrInvalidCursor is returned when the cursor of page can not be used
+	ListUsers(filter UserFilter, page Page) ([]*models.User, string, error)
+	// CountUsers will return the number of users matching the filter
+	CountUsers(filter UserFilter) (int, error)
+	// CreateUser will insert the new user, ErrUserExists is returned when the user already exists
+	CreateUser(user *models.User) error
+	// UpdateUser will replace the fields of existing user, sql.ErrNoRows is returned when the user does not exist
+	// and ErrParentMerged when the user is merged into a parent user which is merged into the user
+	UpdateUser(user *models.User) error
+	// MergeUser will merge the user into the parent user by setting its merged_at and parent_user_id in one transaction,
+	// ErrMergeCycle is returned when the parent is merged into the user, ErrAlreadyMerged when the user is merged into another user,
+	// sql.ErrNoRows when the user does not exist and ErrMissingParent when the parent user does not exist
+	MergeUser(userID, parentUserID int, mergedAt time.Time) error
+	// MergeChain will return the user followed by the users it is merged into, the last one is the canonical user
+	// sql.ErrNoRows is returned when the user does not exist and ErrMergeCycle when the chain never ends
+	MergeChain(userID int) ([]*models.User, error)
+	// ListChildren will return every user merged into the user directly or through other merged users, ordered by id
+	ListChildren(userID int) ([]*models.User, error)
+	// DeleteUser will soft delete the user by setting its deleted_at, a deleted user keeps its deleted_at
+	// sql.ErrNoRows is returned when the user does not exist
+	DeleteUser(userID int, deletedAt time.Time) error
+}
+
+// NewUserRepository will return the repository of the database, sqlite is used in lite mode and postgres otherwise
+func NewUserRepository(db *database.Database) UserRepository {
+	if db.SQLiteDB != nil {
+		return NewSQLiteRepository(db.SQLiteDB)
+	}
+	return NewPostgresRepository(db.PgDB)
+}
+
+// dialect is the sql which differs between postgres and sqlite
+type dialect struct {
+	// placeholder returns the placeholder of query argument at position, starting from 1
+	placeholder func(position int) string
+	// ilike is the case insensitive like operator
+	ilike string
+	// latest returns the latest of created_at, deleted_at and merged_at of table, ignoring the null timestamps
+	latest func(table string) string
+	// lockMerges is the statement which serializes the merges within their transaction, empty when writes are serialized anyway
+	lockMerges string
+}
+
+// scanner is implemented by sql.Row and sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// selectUsers selects the columns read by scanUser
+const selectUsers = "SELECT id, first_name, last_name, email_address, created_at, deleted_at, merged_at, parent_user_id FROM users"
+
+// scanUser will scan the user selected by selectUsers
+func scanUser(row scanner) (*models.User, error) {
+	user := &models.User{}
+	err := row.Scan(&user.ID, &user.FirstName, &user.LastName, &user.EmailAddress, &user.CreatedAt, &user.DeletedAt, &user.MergedAt, &user.ParentUserID)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// querier is implemented by sql.DB and sql.Tx
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// queryUsers will query the users selected by selectUsers
+func queryUsers(db querier, query string, args ...any) ([]*models.User, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// createUser will insert the user, timestamps are written in UTC so they are ordered as text by sqlite
+func createUser(db *sql.DB, d dialect, user *models.User) error {
+	_, err := db.Exec("INSERT INTO users (id, first_name, last_name, email_address, created_at, deleted_at, merged_at, parent_user_id) VALUES ("+
+		d.placeholder(1)+", "+d.placeholder(2)+", "+d.placeholder(3)+", "+d.placeholder(4)+", "+d.placeholder(5)+", "+d.placeholder(6)+", "+d.placeholder(7)+", "+d.placeholder(8)+");",
+		user.ID, user.FirstName, user.LastName, user.EmailAddress, user.CreatedAt.UTC(), utc(user.DeletedAt), utc(user.MergedAt), user.ParentUserID,
+	)
+	return err
+}
+
+// updateUser will replace the fields of the user, the parent is checked under the lock of merges,
+// so the update and a merge can not make a cycle together
+func updateUser(db *sql.DB, d dialect, user *models.User) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// User can be merged only into a user which is not merged into this user
+	if user.ParentUserID != nil && user.MergedAt != nil && *user.ParentUserID != user.ID {
+		err = lockMerges(tx, d)
+		if err != nil {
+			return err
+		}
+
+		merged, err := mergedInto(tx, d, *user.ParentUserID, user.ID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if merged {
+			return ErrParentMerged
+		}
+	}
+
+	res, err := tx.Exec("UPDATE users SET first_name = "+d.placeholder(1)+", last_name = "+d.placeholder(2)+", email_address = "+d.placeholder(3)+
+		", created_at = "+d.placeholder(4)+", deleted_at = "+d.placeholder(5)+", merged_at = "+d.placeholder(6)+", parent_user_id = "+d.placeholder(7)+
+		" WHERE id = "+d.placeholder(8)+";",
+		user.FirstName, user.LastName, user.EmailAddress, user.CreatedAt.UTC(), utc(user.DeletedAt), utc(user.MergedAt), user.ParentUserID, user.ID,
+	)
+	err = affected(res, err)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deleteUser will set deleted_at of the user unless it is already deleted
+func deleteUser(db *sql.DB, d dialect, userID int, deletedAt time.Time) error {
+	res, err := db.Exec("UPDATE users SET deleted_at = COALESCE(deleted_at, "+d.placeholder(1)+") WHERE id = "+d.placeholder(2)+";", deletedAt.UTC(), userID)
+	return affected(res, err)
+}
+
+// affected will return sql.ErrNoRows when the statement changed no row
+func affected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// utc will return the timestamp in UTC, nil stays nil
+func utc(at *time.Time) *time.Time {
+	if at == nil {
+		return nil
+	}
+	t := at.UTC()
+	return &t
+}
@@ -0,0 +1,546 @@
+package userservice_test
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vatsal3003/viswals/internal/consts"
+	"github.com/vatsal3003/viswals/internal/database"
+	"github.com/vatsal3003/viswals/internal/service/userservice"
+	"github.com/vatsal3003/viswals/models"
+	"go.uber.org/zap"
+)
+
+func TestSQLiteRepository(t *testing.T) {
+	testUserRepository(t, func(t *testing.T) userservice.UserRepository {
+		db, err := database.NewLite(zap.NewNop(), filepath.Join(t.TempDir(), "users.db"))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		t.Cleanup(db.Close)
+
+		return userservice.NewUserRepository(db)
+	})
+}
+
+func TestPostgresRepository(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping postgres tests in short mode")
+	}
+	if os.Getenv(consts.PostgresConnURL) == "" {
+		t.Skip("Skipping postgres tests as " + consts.PostgresConnURL + " is not set")
+	}
+
+	db, err := database.NewPostgres(zap.NewNop())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(db.Close)
+
+	// Tests run on the schema of migrations, so a new database is migrated first
+	err = db.Migrate(zap.NewNop(), 0)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	testUserRepository(t, func(t *testing.T) userservice.UserRepository {
+		_, err := db.PgDB.Exec("TRUNCATE users, user_lineage;")
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+
+		return userservice.NewUserRepository(db)
+	})
+}
+
+// testUserRepository is the conformance suite every repository has to pass, newRepository returns an empty repository
+func testUserRepository(t *testing.T, newRepository func(t *testing.T) userservice.UserRepository) {
+	createdAt := time.UnixMilli(1361459822000)
+	parentUserID := 8
+
+	newUser := func(id int, firstName, lastName string, changedAt time.Time) *models.User {
+		return &models.User{
+			ID:           id,
+			FirstName:    firstName,
+			LastName:     lastName,
+			EmailAddress: firstName + "@inbox.edu",
+			CreatedAt:    changedAt,
+		}
+	}
+	lineage := func(id int) models.UserLineage {
+		return models.UserLineage{UserID: id, SchemaVersion: 1, Source: "users.csv", Line: id, BatchID: "batch", PublishedAt: time.Now()}
+	}
+	ids := func(users []*models.User) []int {
+		ids := make([]int, 0, len(users))
+		for _, user := range users {
+			ids = append(ids, user.ID)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+
+	t.Run("Insert and get user", func(t *testing.T) {
+		repo := newRepository(t)
+
+		_, err := repo.InsertUser(newUser(parentUserID, "Hanah", "Schmidt", createdAt), lineage(parentUserID), userservice.ConflictIgnore)
+		assert.NoError(t, err)
+
+		deletedAt := createdAt.Add(time.Hour)
+		user := newUser(13, "Grace", "Taylor", createdAt)
+		user.DeletedAt = &deletedAt
+		user.ParentUserID = &parentUserID
+
+		written, err := repo.InsertUser(user, lineage(13), userservice.ConflictIgnore)
+		assert.NoError(t, err)
+		assert.True(t, written)
+
+		got, err := repo.GetUser(13)
+		if assert.NoError(t, err) {
+			assert.Equal(t, user.FirstName, got.FirstName)
+			assert.Equal(t, user.EmailAddress, got.EmailAddress)
+			assert.True(t, user.CreatedAt.Equal(got.CreatedAt))
+			if assert.NotNil(t, got.DeletedAt) {
+				assert.True(t, deletedAt.Equal(*got.DeletedAt))
+			}
+			assert.Nil(t, got.MergedAt)
+			assert.Equal(t, &parentUserID, got.ParentUserID)
+		}
+	})
+
+	t.Run("Create, update and delete user", func(t *testing.T) {
+		repo := newRepository(t)
+
+		err := repo.CreateUser(newUser(parentUserID, "Hanah", "Schmidt", createdAt))
+		assert.NoError(t, err)
+
+		err = repo.CreateUser(newUser(parentUserID, "Emily", "Tamm", createdAt))
+		assert.ErrorIs(t, err, userservice.ErrUserExists)
+
+		missingParentUserID := 404
+		user := newUser(13, "Grace", "Taylor", createdAt)
+		user.ParentUserID = &missingParentUserID
+		err = repo.CreateUser(user)
+		assert.ErrorIs(t, err, userservice.ErrMissingParent)
+
+		user.ParentUserID = &parentUserID
+		err = repo.CreateUser(user)
+		assert.NoError(t, err)
+
+		mergedAt := createdAt.Add(time.Hour)
+		user.LastName = "Schmidt"
+		user.MergedAt = &mergedAt
+		err = repo.UpdateUser(user)
+		assert.NoError(t, err)
+
+		err = repo.UpdateUser(newUser(404, "Emily", "Tamm", createdAt))
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		// Deleted user keeps its first deleted_at
+		deletedAt := createdAt.Add(2 * time.Hour)
+		err = repo.DeleteUser(13, deletedAt)
+		assert.NoError(t, err)
+		err = repo.DeleteUser(13, deletedAt.Add(time.Hour))
+		assert.NoError(t, err)
+
+		err = repo.DeleteUser(404, deletedAt)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		got, err := repo.GetUser(13)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "Schmidt", got.LastName)
+			if assert.NotNil(t, got.MergedAt) {
+				assert.True(t, mergedAt.Equal(*got.MergedAt))
+			}
+			if assert.NotNil(t, got.DeletedAt) {
+				assert.True(t, deletedAt.Equal(*got.DeletedAt))
+			}
+		}
+	})
+
+	t.Run("Merge users", func(t *testing.T) {
+		repo := newRepository(t)
+
+		_, err := repo.InsertUsers([]*models.User{
+			newUser(8, "Hanah", "Schmidt", createdAt),
+			newUser(13, "Grace", "Taylor", createdAt),
+			newUser(31, "Emily", "Tamm", createdAt),
+			newUser(42, "Grace", "Taylor", createdAt),
+		}, nil, userservice.ConflictOverwrite)
+		assert.NoError(t, err)
+
+		mergedAt := createdAt.Add(time.Hour)
+
+		// 42 -> 13 -> 8 and 31 -> 8
+		assert.NoError(t, repo.MergeUser(13, 8, mergedAt))
+		assert.NoError(t, repo.MergeUser(42, 13, mergedAt))
+		assert.NoError(t, repo.MergeUser(31, 8, mergedAt))
+		assert.NoError(t, repo.MergeUser(31, 8, mergedAt.Add(time.Hour)), "merging again into the same parent should be kept")
+
+		assert.ErrorIs(t, repo.MergeUser(8, 42, mergedAt), userservice.ErrMergeCycle)
+		assert.ErrorIs(t, repo.MergeUser(8, 8, mergedAt), userservice.ErrMergeCycle)
+		assert.ErrorIs(t, repo.MergeUser(42, 31, mergedAt), userservice.ErrAlreadyMerged)
+		assert.ErrorIs(t, repo.MergeUser(8, 404, mergedAt), userservice.ErrMissingParent)
+		assert.ErrorIs(t, repo.MergeUser(404, 8, mergedAt), sql.ErrNoRows)
+
+		// User having a parent without merged_at is not merged yet, so its parent is not followed
+		unmerged := newUser(55, "Noah", "Brown", createdAt)
+		unmerged.ParentUserID = &[]int{8}[0]
+		_, err = repo.InsertUser(unmerged, lineage(55), userservice.ConflictIgnore)
+		assert.NoError(t, err)
+
+		chain, err := repo.MergeChain(55)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{55}, ids(chain))
+
+		children, err := repo.ListChildren(8)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{13, 31, 42}, ids(children))
+
+		// and it can be merged into any user, or have its parent merged into it
+		linked := newUser(66, "Liam", "Brown", createdAt)
+		linked.ParentUserID = &[]int{77}[0]
+		_, err = repo.InsertUsers([]*models.User{linked, newUser(77, "Liam", "Brown", createdAt)}, nil, userservice.ConflictIgnore)
+		assert.NoError(t, err)
+		assert.NoError(t, repo.MergeUser(77, 66, mergedAt))
+
+		chain, err = repo.MergeChain(77)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{66, 77}, ids(chain))
+
+		assert.NoError(t, repo.MergeUser(55, 31, mergedAt))
+		assert.ErrorIs(t, repo.MergeUser(55, 13, mergedAt), userservice.ErrAlreadyMerged)
+
+		chain, err = repo.MergeChain(42)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{42, 13, 8}, []int{chain[0].ID, chain[1].ID, chain[2].ID})
+
+		chain, err = repo.MergeChain(8)
+		assert.NoError(t, err)
+		assert.Len(t, chain, 1)
+
+		_, err = repo.MergeChain(404)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		got, err := repo.GetUser(31)
+		if assert.NoError(t, err) && assert.NotNil(t, got.MergedAt) {
+			assert.True(t, mergedAt.Equal(*got.MergedAt))
+		}
+
+		children, err = repo.ListChildren(8)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{13, 31, 42, 55}, ids(children))
+
+		children, err = repo.ListChildren(42)
+		assert.NoError(t, err)
+		assert.Empty(t, children)
+
+		// Parent merged into the user is rejected by update, but not by ingestion
+		cyclic := newUser(8, "Hanah", "Schmidt", createdAt)
+		cyclic.ParentUserID = &[]int{42}[0]
+		cyclic.MergedAt = &mergedAt
+		assert.ErrorIs(t, repo.UpdateUser(cyclic), userservice.ErrParentMerged)
+
+		// Cycle made by ingested users ends the chain
+		_, err = repo.InsertUser(cyclic, lineage(8), userservice.ConflictOverwrite)
+		assert.NoError(t, err)
+
+		_, err = repo.MergeChain(42)
+		assert.ErrorIs(t, err, userservice.ErrMergeCycle)
+
+		children, err = repo.ListChildren(8)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{13, 31, 42, 55}, ids(children))
+	})
+
+	t.Run("Get missing user", func(t *testing.T) {
+		repo := newRepository(t)
+
+		_, err := repo.GetUser(404)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("Conflict policies", func(t *testing.T) {
+		tests := []struct {
+			policy      userservice.ConflictPolicy
+			changedAt   time.Time
+			wantWritten bool
+			wantName    string
+		}{
+			{policy: userservice.ConflictIgnore, changedAt: createdAt.Add(time.Hour), wantWritten: false, wantName: "Grace"},
+			{policy: userservice.ConflictOverwrite, changedAt: createdAt.Add(-time.Hour), wantWritten: true, wantName: "Emily"},
+			{policy: userservice.ConflictNewer, changedAt: createdAt.Add(-time.Hour), wantWritten: false, wantName: "Grace"},
+			{policy: userservice.ConflictNewer, changedAt: createdAt.Add(time.Hour), wantWritten: true, wantName: "Emily"},
+		}
+
+		for _, tt := range tests {
+			t.Run(string(tt.policy), func(t *testing.T) {
+				repo := newRepository(t)
+
+				_, err := repo.InsertUser(newUser(13, "Grace", "Taylor", createdAt), lineage(13), tt.policy)
+				assert.NoError(t, err)
+
+				written, err := repo.InsertUser(newUser(13, "Emily", "Tamm", tt.changedAt), lineage(13), tt.policy)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantWritten, written)
+
+				got, err := repo.GetUser(13)
+				if assert.NoError(t, err) {
+					assert.Equal(t, tt.wantName, got.FirstName)
+				}
+			})
+		}
+	})
+
+	t.Run("Insert batch of users", func(t *testing.T) {
+		repo := newRepository(t)
+
+		_, err := repo.InsertUser(newUser(8, "Hanah", "Schmidt", createdAt), lineage(8), userservice.ConflictIgnore)
+		assert.NoError(t, err)
+
+		// Repeated user is written once, the newer one wins
+		written, err := repo.InsertUsers([]*models.User{
+			newUser(8, "Hanah", "Schmidt", createdAt.Add(-time.Hour)),
+			newUser(31, "Emily", "Tamm", createdAt.Add(time.Hour)),
+			newUser(42, "Grace", "Taylor", createdAt),
+			newUser(31, "Emilia", "Tamm", createdAt),
+		}, []models.UserLineage{lineage(8), lineage(31), lineage(42), lineage(31)}, userservice.ConflictNewer)
+		assert.NoError(t, err)
+		slices.Sort(written)
+		assert.Equal(t, []int{31, 42}, written)
+
+		got, err := repo.GetUser(31)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "Emily", got.FirstName)
+		}
+
+		users, _, err := repo.ListUsers(userservice.UserFilter{}, userservice.Page{})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{8, 31, 42}, ids(users))
+	})
+
+	t.Run("Insert batch of users by conflict policy", func(t *testing.T) {
+		deletedAt := createdAt.Add(2 * time.Hour)
+		deleted := newUser(42, "Gracie", "Taylor", createdAt.Add(-time.Hour))
+		deleted.DeletedAt = &deletedAt
+
+		tests := []struct {
+			policy      userservice.ConflictPolicy
+			wantWritten []int
+			wantNames   map[int]string
+		}{
+			// Repeated user of batch is resolved before the existing one, the last one wins
+			{policy: userservice.ConflictIgnore, wantWritten: []int{31}, wantNames: map[int]string{8: "Hanah", 31: "Emilia", 42: "Grace"}},
+			{policy: userservice.ConflictOverwrite, wantWritten: []int{8, 31, 42}, wantNames: map[int]string{8: "Hanna", 31: "Emilia", 42: "Gracie"}},
+			// The newest one wins, deleted_at is later than created_at of existing user
+			{policy: userservice.ConflictNewer, wantWritten: []int{8, 31, 42}, wantNames: map[int]string{8: "Hanna", 31: "Emily", 42: "Gracie"}},
+		}
+
+		for _, tt := range tests {
+			t.Run(string(tt.policy), func(t *testing.T) {
+				repo := newRepository(t)
+
+				for _, user := range []*models.User{newUser(8, "Hanah", "Schmidt", createdAt), newUser(42, "Grace", "Taylor", createdAt)} {
+					_, err := repo.InsertUser(user, lineage(user.ID), userservice.ConflictIgnore)
+					assert.NoError(t, err)
+				}
+
+				written, err := repo.InsertUsers([]*models.User{
+					newUser(8, "Hanna", "Schmidt", createdAt.Add(time.Hour)),
+					newUser(31, "Emily", "Tamm", createdAt.Add(time.Hour)),
+					deleted,
+					newUser(31, "Emilia", "Tamm", createdAt),
+				}, []models.UserLineage{lineage(8), lineage(31), lineage(42), lineage(31)}, tt.policy)
+				assert.NoError(t, err)
+				slices.Sort(written)
+				assert.Equal(t, tt.wantWritten, written)
+
+				for id, name := range tt.wantNames {
+					got, err := repo.GetUser(id)
+					if assert.NoError(t, err) {
+						assert.Equal(t, name, got.FirstName, "user %d", id)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("Parent user", func(t *testing.T) {
+		mergedAt := createdAt.Add(time.Hour)
+		child := func(id int, parentUserID *int) *models.User {
+			user := newUser(id, "Grace", "Taylor", createdAt)
+			user.MergedAt = &mergedAt
+			user.ParentUserID = parentUserID
+			return user
+		}
+		missingParentUserID := 404
+
+		tests := []struct {
+			name    string
+			users   []*models.User
+			wantErr error
+		}{
+			{name: "Parent after child in batch", users: []*models.User{child(13, &parentUserID), newUser(parentUserID, "Hanah", "Schmidt", createdAt)}},
+			{name: "Missing parent", users: []*models.User{child(13, &missingParentUserID), newUser(parentUserID, "Hanah", "Schmidt", createdAt)}, wantErr: userservice.ErrMissingParent},
+			{name: "Merged without parent", users: []*models.User{child(13, nil)}, wantErr: userservice.ErrInvalidUser},
+			{name: "Parent is itself", users: []*models.User{child(13, &[]int{13}[0])}, wantErr: userservice.ErrInvalidUser},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				repo := newRepository(t)
+
+				_, err := repo.InsertUsers(tt.users, nil, userservice.ConflictOverwrite)
+				if tt.wantErr != nil {
+					assert.ErrorIs(t, err, tt.wantErr)
+
+					// Batch is stored at once, so nothing is stored
+					users, _, err := repo.ListUsers(userservice.UserFilter{}, userservice.Page{})
+					assert.NoError(t, err)
+					assert.Empty(t, users)
+					return
+				}
+				assert.NoError(t, err)
+
+				users, _, err := repo.ListUsers(userservice.UserFilter{}, userservice.Page{})
+				assert.NoError(t, err)
+				assert.Equal(t, []int{parentUserID, 13}, ids(users))
+			})
+		}
+	})
+
+	t.Run("List users by filter", func(t *testing.T) {
+		repo := newRepository(t)
+
+		deletedAt := createdAt.Add(3 * time.Hour)
+		deleted := newUser(42, "Grace", "Taylor", createdAt.Add(2*time.Hour))
+		deleted.DeletedAt = &deletedAt
+		merged := newUser(60, "Emma", "Schmidt", createdAt.Add(time.Hour))
+		merged.MergedAt = &deletedAt
+		merged.ParentUserID = &parentUserID
+
+		_, err := repo.InsertUsers([]*models.User{
+			newUser(8, "Hanah", "Schmidt", createdAt),
+			newUser(31, "Emily", "Tamm", createdAt),
+			deleted,
+			newUser(50, "100%", "Sure", createdAt),
+			merged,
+		}, nil, userservice.ConflictOverwrite)
+		assert.NoError(t, err)
+
+		yes, no := true, false
+		createdBefore := createdAt.Add(time.Hour)
+
+		tests := []struct {
+			name   string
+			filter userservice.UserFilter
+			want   []int
+		}{
+			{name: "No filter", filter: userservice.UserFilter{}, want: []int{8, 31, 42, 50, 60}},
+			{name: "First name prefix", filter: userservice.UserFilter{FirstName: "gra"}, want: []int{42}},
+			{name: "Last name prefix", filter: userservice.UserFilter{LastName: "Ta"}, want: []int{31, 42}},
+			{name: "Both names", filter: userservice.UserFilter{FirstName: "Em", LastName: "Tamm"}, want: []int{31}},
+			{name: "Either name", filter: userservice.UserFilter{FirstName: "Hanah", LastName: "Tamm", Combine: userservice.CombineOr}, want: []int{8, 31}},
+			{name: "Exact name", filter: userservice.UserFilter{LastName: "tamm", Match: userservice.MatchExact}, want: []int{31}},
+			{name: "Exact name is not prefix", filter: userservice.UserFilter{LastName: "Tam", Match: userservice.MatchExact}, want: []int{}},
+			{name: "Name contains", filter: userservice.UserFilter{LastName: "MI", Match: userservice.MatchContains}, want: []int{8, 60}},
+			{name: "Wildcard is matched as it is", filter: userservice.UserFilter{FirstName: "1%"}, want: []int{}},
+			{name: "Escaped wildcard", filter: userservice.UserFilter{FirstName: "100%"}, want: []int{50}},
+			{name: "Injected quote is matched as it is", filter: userservice.UserFilter{FirstName: "' OR '1'='1"}, want: []int{}},
+			{name: "No match", filter: userservice.UserFilter{FirstName: "Zoe"}, want: []int{}},
+			{name: "Ids", filter: userservice.UserFilter{IDs: []int{8, 42, 404}}, want: []int{8, 42}},
+			{name: "Created from", filter: userservice.UserFilter{CreatedFrom: &createdBefore}, want: []int{42, 60}},
+			{name: "Created before", filter: userservice.UserFilter{CreatedBefore: &createdBefore}, want: []int{8, 31, 50}},
+			{name: "Deleted", filter: userservice.UserFilter{Deleted: &yes}, want: []int{42}},
+			{name: "Not merged", filter: userservice.UserFilter{Merged: &no}, want: []int{8, 31, 42, 50}},
+			{name: "Parent user", filter: userservice.UserFilter{ParentUserID: &parentUserID}, want: []int{60}},
+			{name: "Deleted or merged", filter: userservice.UserFilter{Deleted: &yes, Merged: &yes, Combine: userservice.CombineOr}, want: []int{42, 60}},
+			{name: "Name and created before", filter: userservice.UserFilter{LastName: "Schmidt", CreatedBefore: &createdBefore}, want: []int{8}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				users, next, err := repo.ListUsers(tt.filter, userservice.Page{})
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, ids(users))
+				assert.Empty(t, next)
+
+				count, err := repo.CountUsers(tt.filter)
+				assert.NoError(t, err)
+				assert.Equal(t, len(tt.want), count)
+			})
+		}
+	})
+
+	t.Run("List users by page", func(t *testing.T) {
+		repo := newRepository(t)
+
+		// Names and timestamps are repeated, so the ties are ordered by id
+		_, err := repo.InsertUsers([]*models.User{
+			newUser(8, "Hanah", "Schmidt", createdAt.Add(time.Hour)),
+			newUser(13, "Grace", "Taylor", createdAt),
+			newUser(31, "Emily", "Tamm", createdAt.Add(time.Hour)),
+			newUser(42, "Grace", "Taylor", createdAt.Add(2*time.Hour)),
+			newUser(50, "Emma", "Schmidt", createdAt),
+		}, nil, userservice.ConflictOverwrite)
+		assert.NoError(t, err)
+
+		tests := []struct {
+			name   string
+			filter userservice.UserFilter
+			page   userservice.Page
+			want   [][]int
+		}{
+			{name: "Id", page: userservice.Page{Limit: 2}, want: [][]int{{8, 13}, {31, 42}, {50}}},
+			{name: "Id descending", page: userservice.Page{Limit: 2, Order: userservice.OrderDesc}, want: [][]int{{50, 42}, {31, 13}, {8}}},
+			{name: "Created at", page: userservice.Page{Limit: 2, Sort: userservice.SortCreatedAt}, want: [][]int{{13, 50}, {8, 31}, {42}}},
+			{name: "Created at descending", page: userservice.Page{Limit: 3, Sort: userservice.SortCreatedAt, Order: userservice.OrderDesc}, want: [][]int{{42, 31, 8}, {50, 13}}},
+			{name: "Last name", page: userservice.Page{Limit: 2, Sort: userservice.SortLastName}, want: [][]int{{8, 50}, {31, 13}, {42}}},
+			{name: "Last name with filter", filter: userservice.UserFilter{FirstName: "Gr", LastName: "Sch", Combine: userservice.CombineOr}, page: userservice.Page{Limit: 1, Sort: userservice.SortLastName}, want: [][]int{{8}, {50}, {13}, {42}}},
+			{name: "Exact last page", page: userservice.Page{Limit: 5}, want: [][]int{{8, 13, 31, 42, 50}}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				page := tt.page
+				var got [][]int
+				for {
+					users, next, err := repo.ListUsers(tt.filter, page)
+					if !assert.NoError(t, err) {
+						return
+					}
+
+					pageIDs := make([]int, len(users))
+					for i, user := range users {
+						pageIDs[i] = user.ID
+					}
+					got = append(got, pageIDs)
+
+					if next == "" {
+						break
+					}
+					page.Cursor = next
+				}
+				assert.Equal(t, tt.want, got)
+			})
+		}
+
+		t.Run("Invalid cursor", func(t *testing.T) {
+			_, next, err := repo.ListUsers(userservice.UserFilter{}, userservice.Page{Limit: 1})
+			assert.NoError(t, err)
+
+			for _, page := range []userservice.Page{
+				{Cursor: "not a cursor"},
+				{Cursor: next, Sort: userservice.SortLastName},
+				{Cursor: next, Order: userservice.OrderDesc},
+			} {
+				_, _, err = repo.ListUsers(userservice.UserFilter{}, page)
+				assert.ErrorIs(t, err, userservice.ErrInvalidCursor)
+			}
+		})
+	})
+}
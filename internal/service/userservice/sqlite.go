@@ -0,0 +1,193 @@
+package userservice
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/vatsal3003/viswals/models"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqliteDialect uses anonymous placeholders, its LIKE is case insensitive and multi-argument MAX returns null
+// when any argument is null, so the null timestamps are replaced by created_at
+var sqliteDialect = dialect{
+	placeholder: func(position int) string {
+		return "?"
+	},
+	ilike: "LIKE",
+	latest: func(table string) string {
+		return "MAX(" + table + ".created_at, COALESCE(" + table + ".deleted_at, " + table + ".created_at), COALESCE(" + table + ".merged_at, " + table + ".created_at))"
+	},
+}
+
+// SQLiteRepository stores the users in sqlite, timestamps are stored in UTC so they are ordered as text
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteRepository will create the repository over sqlite connection
+func NewSQLiteRepository(db *sql.DB) *SQLiteRepository {
+	return &SQLiteRepository{db: db}
+}
+
+func (r *SQLiteRepository) InsertUser(user *models.User, lineage models.UserLineage, policy ConflictPolicy) (bool, error) {
+	written, err := r.InsertUsers([]*models.User{user}, []models.UserLineage{lineage}, policy)
+	if err != nil {
+		return false, err
+	}
+	return len(written) != 0, nil
+}
+
+// InsertUsers will insert the users one by one in a single transaction, as sqlite has no bulk copy
+func (r *SQLiteRepository) InsertUsers(users []*models.User, lineages []models.UserLineage, policy ConflictPolicy) (_ []int, err error) {
+	defer func() { err = sqliteError(err) }()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	insertUser, err := tx.Prepare("INSERT INTO users VALUES (?, ?, ?, ?, ?, ?, ?, ?)" + policy.onConflict(sqliteDialect) + ";")
+	if err != nil {
+		return nil, err
+	}
+	defer insertUser.Close()
+
+	var written []int
+	for _, user := range policy.dedupe(users) {
+		var id int
+		err = insertUser.QueryRow(user.ID, user.FirstName, user.LastName, user.EmailAddress, user.CreatedAt.UTC(), utc(user.DeletedAt), utc(user.MergedAt), user.ParentUserID).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		written = append(written, id)
+	}
+
+	insertLineage, err := tx.Prepare("INSERT INTO user_lineage (user_id, schema_version, source, line, batch_id, published_at) VALUES (?, ?, ?, ?, ?, ?);")
+	if err != nil {
+		return nil, err
+	}
+	defer insertLineage.Close()
+
+	for _, lineage := range lineages {
+		published := publishedAt(lineage)
+		published.Time = published.Time.UTC()
+
+		_, err = insertLineage.Exec(lineage.UserID, lineage.SchemaVersion, lineage.Source, lineage.Line, lineage.BatchID, published)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = checkParents(tx, written)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return written, nil
+}
+
+// checkParents will check the deferred parent foreign key of the written users before commit, as sqlite keeps the transaction open
+// when commit fails on a foreign key, and database/sql can not roll it back after commit
+// only the written users are checked, as the other users are checked when they are written
+func checkParents(tx *sql.Tx, written []int) error {
+	if len(written) == 0 {
+		return nil
+	}
+
+	q := &query{d: sqliteDialect}
+	ids := make([]string, len(written))
+	for i, id := range written {
+		ids[i] = q.arg(id)
+	}
+
+	var orphan int
+	err := tx.QueryRow(`SELECT id FROM users AS child WHERE id IN (`+strings.Join(ids, ", ")+`) AND parent_user_id IS NOT NULL
+		AND NOT EXISTS (SELECT 1 FROM users WHERE users.id = child.parent_user_id) LIMIT 1;`, q.args...).Scan(&orphan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return &databaseError{kind: ErrMissingParent, err: errors.New("FOREIGN KEY constraint failed")}
+}
+
+func (r *SQLiteRepository) GetUser(userID int) (*models.User, error) {
+	user, err := scanUser(r.db.QueryRow(selectUsers+" WHERE id = ?", userID))
+	return user, sqliteError(err)
+}
+
+func (r *SQLiteRepository) ListUsers(filter UserFilter, page Page) ([]*models.User, string, error) {
+	users, next, err := listUsers(r.db, sqliteDialect, filter, page)
+	return users, next, sqliteError(err)
+}
+
+func (r *SQLiteRepository) CountUsers(filter UserFilter) (int, error) {
+	count, err := countUsers(r.db, sqliteDialect, filter)
+	return count, sqliteError(err)
+}
+
+func (r *SQLiteRepository) CreateUser(user *models.User) error {
+	return sqliteError(createUser(r.db, sqliteDialect, user))
+}
+
+func (r *SQLiteRepository) UpdateUser(user *models.User) error {
+	return sqliteError(updateUser(r.db, sqliteDialect, user))
+}
+
+func (r *SQLiteRepository) MergeUser(userID, parentUserID int, mergedAt time.Time) error {
+	return sqliteError(mergeUser(r.db, sqliteDialect, userID, parentUserID, mergedAt))
+}
+
+func (r *SQLiteRepository) MergeChain(userID int) ([]*models.User, error) {
+	chain, err := mergeChain(r.db, sqliteDialect, userID)
+	return chain, sqliteError(err)
+}
+
+func (r *SQLiteRepository) ListChildren(userID int) ([]*models.User, error) {
+	children, err := listChildren(r.db, sqliteDialect, userID)
+	return children, sqliteError(err)
+}
+
+func (r *SQLiteRepository) DeleteUser(userID int, deletedAt time.Time) error {
+	return sqliteError(deleteUser(r.db, sqliteDialect, userID, deletedAt))
+}
+
+// sqliteError will tell the constraint violations and the locked database of sqlite apart, the missing parent is told by checkParents
+func sqliteError(err error) error {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return err
+	}
+
+	// Primary result code is the lowest byte of extended result code
+	switch sqliteErr.Code() & 0xff {
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		return &databaseError{kind: ErrUnavailable, err: err}
+	}
+
+	switch sqliteErr.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+		return &databaseError{kind: ErrUserExists, err: err}
+	case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+		return &databaseError{kind: ErrMissingParent, err: err}
+	case sqlite3.SQLITE_CONSTRAINT_NOTNULL, sqlite3.SQLITE_CONSTRAINT_CHECK:
+		return &databaseError{kind: ErrInvalidUser, err: err}
+	default:
+		return err
+	}
+}
@@ -2,112 +2,108 @@ package userservice
 
 import (
 	"bytes"
-	"context"
 	"encoding/gob"
+	"errors"
 	"log"
 	"strconv"
 	"time"
 
-	"github.com/lib/pq"
-	"github.com/redis/go-redis/v9"
-	"github.com/vatsal3003/viswals/internal/database"
+	"github.com/vatsal3003/viswals/internal/cache"
 	"github.com/vatsal3003/viswals/internal/encryption"
 	"github.com/vatsal3003/viswals/models"
 )
 
-func InsertUser(db *database.Database, user *models.User) error {
-	_, err := db.PgDB.Exec("INSERT INTO users VALUES ($1, $2, $3, $4, $5, $6, $7, $8);", user.ID, user.FirstName, user.LastName, user.EmailAddress, user.CreatedAt, user.DeletedAt, user.MergedAt, user.ParentUserID)
-	if err != nil {
-		pqErr := err.(*pq.Error)
-		if pqErr.Code == "23505" && pqErr.Constraint == "users_pkey" {
-			return nil
-		}
-		return err
-	}
+// cacheKey is the key of the user in cache
+func cacheKey(userID int) string {
+	return "users:" + strconv.Itoa(userID)
+}
 
-	return nil
+func InsertUserInKVStore(userCache cache.Cache, userID int, user []byte) error {
+	return InsertUsersInKVStore(userCache, map[int][]byte{userID: user})
 }
 
-func InsertUserInKVStore(db *database.Database, userID int, user []byte) error {
-	status := db.RedisDB.Set(context.Background(), "users:"+strconv.Itoa(userID), user, 2*time.Minute)
-	if status.Err() != nil {
+// InsertUsersInKVStore will set the gob encoded users keyed by their id at once
+func InsertUsersInKVStore(userCache cache.Cache, users map[int][]byte) error {
+	if len(users) == 0 {
+		return nil
+	}
+
+	values := make(map[string][]byte, len(users))
+	for userID, user := range users {
+		values[cacheKey(userID)] = user
+	}
+
+	err := userCache.Set(values)
+	if err != nil {
 		// If there is error during inserting in cache, do nothing as its not critical task
-		log.Println("ERROR failed to set the user:" + status.Err().Error())
+		log.Println("ERROR failed to set the users:" + err.Error())
 	}
 
 	return nil
 }
 
-func GetAllUsers(db *database.Database, filters map[string]string) ([]*models.User, error) {
-	whereClause := " WHERE "
-
-	hasAnyFilterApplied := false
+// DeleteUsersFromKVStore will remove the cached users, so they are read from database next time
+func DeleteUsersFromKVStore(userCache cache.Cache, userIDs []int) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
 
-	fname, ok := filters["first_name"]
-	if ok {
-		if hasAnyFilterApplied {
-			whereClause += "OR first_name ILIKE '" + fname + "%' "
-		} else {
-			whereClause += "first_name ILIKE '" + fname + "%' "
-		}
-		hasAnyFilterApplied = true
+	keys := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		keys[i] = cacheKey(userID)
 	}
 
-	lname, ok := filters["last_name"]
-	if ok {
-		if hasAnyFilterApplied {
-			whereClause += "OR last_name ILIKE '" + lname + "%' "
-		} else {
-			whereClause += "last_name ILIKE '" + lname + "%' "
-		}
-		hasAnyFilterApplied = true
+	err := userCache.Delete(keys...)
+	if err != nil {
+		// If there is error during deleting from cache, do nothing as its not critical task
+		log.Println("ERROR failed to delete the users:" + err.Error())
 	}
 
-	var users []*models.User
+	return nil
+}
 
-	query := `SELECT id, first_name, last_name, email_address, created_at, deleted_at, merged_at, parent_user_id FROM users`
+// GetAllUsers will return the page of users matching the filter with decrypted email address and the cursor of next page
+func GetAllUsers(repo UserRepository, filter UserFilter, page Page) (_ []*models.User, _ string, err error) {
+	defer func() { err = serviceError(err) }()
 
-	if len(filters) != 0 {
-		query = query + whereClause
+	users, next, err := repo.ListUsers(filter, page)
+	if err != nil {
+		return nil, "", err
 	}
 
-	rows, err := db.PgDB.Query(query)
+	err = decryptUsers(users)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		user := &models.User{}
-		err = rows.Scan(&user.ID, &user.FirstName, &user.LastName, &user.EmailAddress, &user.CreatedAt, &user.DeletedAt, &user.MergedAt, &user.ParentUserID)
-		if err != nil {
-			return nil, err
-		}
-
-		user.EmailAddress, err = encryption.Decrypt(user.EmailAddress)
-		if err != nil {
-			return nil, err
-		}
+	return users, next, nil
+}
 
-		users = append(users, user)
-	}
+// CountUsers will return the number of users matching the filter
+func CountUsers(repo UserRepository, filter UserFilter) (_ int, err error) {
+	defer func() { err = serviceError(err) }()
 
-	return users, nil
+	return repo.CountUsers(filter)
 }
 
-func GetUser(db *database.Database, userID string) (*models.User, error) {
-	var user = new(models.User)
+// GetUser will return the user with decrypted email address from cache, or from repository when it is not cached
+// when cache fails, e.g. redis is down, the user is read from repository as well
+func GetUser(repo UserRepository, userCache cache.Cache, userID string) (_ *models.User, err error) {
+	defer func() { err = serviceError(err) }()
 
-	res, err := db.RedisDB.Get(context.Background(), "users:"+userID).Bytes()
-	if err == redis.Nil {
-		row, err := db.PgDB.Query("SELECT id, first_name, last_name, email_address, created_at, deleted_at, merged_at, parent_user_id FROM users WHERE id = $1", userID)
-		if err != nil {
-			return nil, err
-		}
-		defer row.Close()
+	id, err := strconv.Atoi(userID)
+	if err != nil || id <= 0 {
+		return nil, invalidField("id", "must be a positive integer")
+	}
 
-		row.Next()
-		err = row.Scan(&user.ID, &user.FirstName, &user.LastName, &user.EmailAddress, &user.CreatedAt, &user.DeletedAt, &user.MergedAt, &user.ParentUserID)
+	res, ok, err := userCache.Get(cacheKey(id))
+	if err != nil {
+		// Cache is not critical, read the user from repository
+		log.Println("ERROR failed to get the user from cache:" + err.Error())
+	}
+
+	if !ok {
+		user, err := repo.GetUser(id)
 		if err != nil {
 			return nil, err
 		}
@@ -118,7 +114,7 @@ func GetUser(db *database.Database, userID string) (*models.User, error) {
 			return nil, err
 		}
 
-		_ = db.RedisDB.Set(context.Background(), "users:"+strconv.Itoa(user.ID), buf.Bytes(), 2*time.Minute).Err()
+		_ = InsertUserInKVStore(userCache, user.ID, buf.Bytes())
 
 		user.EmailAddress, err = encryption.Decrypt(user.EmailAddress)
 		if err != nil {
@@ -126,21 +122,195 @@ func GetUser(db *database.Database, userID string) (*models.User, error) {
 		}
 
 		return user, nil
-	} else if err != nil {
+	}
+
+	var user models.User
+	err = gob.NewDecoder(bytes.NewReader(res)).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+
+	user.EmailAddress, err = encryption.Decrypt(user.EmailAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// CreateUser will validate the user and insert it with encrypted email address, the user keeps its plain email address
+// ErrUserExists is returned when the user already exists
+func CreateUser(repo UserRepository, userCache cache.Cache, user *models.User) error {
+	return serviceError(writeUser(userCache, user, repo.CreateUser))
+}
+
+// UpdateUser will validate the user and replace the existing user with it, ErrUserNotFound is returned when the user does not exist
+func UpdateUser(repo UserRepository, userCache cache.Cache, user *models.User) (err error) {
+	defer func() { err = serviceError(err) }()
+
+	return writeUser(userCache, user, repo.UpdateUser)
+}
+
+// PatchUser will apply the patch to the existing user with decrypted email address and update it with the patched user
+func PatchUser(repo UserRepository, userCache cache.Cache, userID int, patch func(user *models.User) error) (_ *models.User, err error) {
+	defer func() { err = serviceError(err) }()
+
+	user, err := repo.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	user.EmailAddress, err = encryption.Decrypt(user.EmailAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	err = patch(user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = UpdateUser(repo, userCache, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// MergeUser will merge the user into the parent user and return the merged user with decrypted email address
+func MergeUser(repo UserRepository, userCache cache.Cache, userID, parentUserID int) (_ *models.User, err error) {
+	defer func() { err = serviceError(err) }()
+
+	err = repo.MergeUser(userID, parentUserID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	_ = DeleteUsersFromKVStore(userCache, []int{userID})
+
+	user, err := repo.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = decryptUsers([]*models.User{user})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// GetCanonicalUser will follow the merge chain of the user and return the user it is finally merged into with decrypted email address,
+// a user which is not merged is its own canonical user
+func GetCanonicalUser(repo UserRepository, userID int) (_ *models.User, err error) {
+	defer func() { err = serviceError(err) }()
+
+	chain, err := repo.MergeChain(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	canonical := chain[len(chain)-1]
+	err = decryptUsers([]*models.User{canonical})
+	if err != nil {
+		return nil, err
+	}
+
+	return canonical, nil
+}
+
+// GetChildUsers will return every user merged into the user with decrypted email address,
+// ErrUserNotFound is returned when the user does not exist
+func GetChildUsers(repo UserRepository, userID int) (_ []*models.User, err error) {
+	defer func() { err = serviceError(err) }()
+
+	_, err = repo.GetUser(userID)
+	if err != nil {
 		return nil, err
-	} else {
+	}
+
+	children, err := repo.ListChildren(userID)
+	if err != nil {
+		return nil, err
+	}
 
-		var user models.User
-		err := gob.NewDecoder(bytes.NewReader(res)).Decode(&user)
+	err = decryptUsers(children)
+	if err != nil {
+		return nil, err
+	}
+
+	return children, nil
+}
+
+// PublishUsers will publish the copies of stored users with decrypted email address to the hub,
+// the user whose email address can not be decrypted is not published
+func PublishUsers(hub *Hub, users []*models.User) {
+	published := make([]*models.User, 0, len(users))
+	for _, user := range users {
+		copied := *user
+
+		var err error
+		copied.EmailAddress, err = encryption.Decrypt(user.EmailAddress)
 		if err != nil {
-			return nil, err
+			log.Println("ERROR failed to decrypt the published user:" + err.Error())
+			continue
 		}
 
+		published = append(published, &copied)
+	}
+
+	hub.Publish(published...)
+}
+
+// decryptUsers will decrypt the email address of the users
+func decryptUsers(users []*models.User) error {
+	for _, user := range users {
+		var err error
 		user.EmailAddress, err = encryption.Decrypt(user.EmailAddress)
 		if err != nil {
-			return nil, err
+			return err
 		}
+	}
+	return nil
+}
 
-		return &user, nil
+// DeleteUser will soft delete the user, ErrUserNotFound is returned when the user does not exist
+func DeleteUser(repo UserRepository, userCache cache.Cache, userID int) (err error) {
+	defer func() { err = serviceError(err) }()
+
+	err = repo.DeleteUser(userID, time.Now())
+	if err != nil {
+		return err
 	}
+
+	return DeleteUsersFromKVStore(userCache, []int{userID})
+}
+
+// writeUser will validate the user and write it with encrypted email address, then remove the cached user
+func writeUser(userCache cache.Cache, user *models.User, write func(user *models.User) error) error {
+	err := ValidateUser(user)
+	if err != nil {
+		return err
+	}
+
+	stored := *user
+	stored.EmailAddress, err = encryption.Encrypt(user.EmailAddress)
+	if err != nil {
+		return errors.New("failed to encrypt the user email address:" + err.Error())
+	}
+
+	err = write(&stored)
+	if errors.Is(err, ErrMissingParent) {
+		return invalidField("parent_user_id", "does not exist")
+	}
+	if errors.Is(err, ErrParentMerged) {
+		return invalidField("parent_user_id", "is merged into the user")
+	}
+	if err != nil {
+		return err
+	}
+
+	return DeleteUsersFromKVStore(userCache, []int{user.ID})
 }
@@ -1,3 +1,221 @@
 package userservice_test
 
-// TODO: write test cases for user service
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vatsal3003/viswals/internal/cache"
+	"github.com/vatsal3003/viswals/internal/database"
+	"github.com/vatsal3003/viswals/internal/encryption"
+	"github.com/vatsal3003/viswals/internal/service/userservice"
+	"github.com/vatsal3003/viswals/models"
+	"go.uber.org/zap"
+)
+
+func TestParseConflictPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    userservice.ConflictPolicy
+		wantErr bool
+	}{
+		{
+			name:  "Empty uses default",
+			input: "",
+			want:  userservice.DefaultConflictPolicy,
+		},
+		{
+			name:  "Ignore",
+			input: "ignore",
+			want:  userservice.ConflictIgnore,
+		},
+		{
+			name:  "Overwrite with spaces and case",
+			input: " Overwrite ",
+			want:  userservice.ConflictOverwrite,
+		},
+		{
+			name:  "Newer",
+			input: "newer",
+			want:  userservice.ConflictNewer,
+		},
+		{
+			name:    "Unknown policy",
+			input:   "merge",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := userservice.ParseConflictPolicy(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, policy)
+		})
+	}
+}
+
+// downCache fails every operation, like redis which is down
+type downCache struct{}
+
+func (downCache) Get(key string) ([]byte, bool, error) {
+	return nil, false, errors.New("connection refused")
+}
+func (downCache) Set(values map[string][]byte) error { return errors.New("connection refused") }
+func (downCache) Delete(keys ...string) error        { return errors.New("connection refused") }
+func (downCache) Stats() cache.Stats                 { return cache.Stats{} }
+
+func TestGetUser(t *testing.T) {
+	db, err := database.NewLite(zap.NewNop(), filepath.Join(t.TempDir(), "users.db"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer db.Close()
+
+	repo := userservice.NewUserRepository(db)
+
+	emailAddress, err := encryption.Encrypt("GraceTaylor1951@inbox.edu")
+	assert.NoError(t, err)
+	_, err = repo.InsertUser(&models.User{ID: 13, FirstName: "Grace", LastName: "Taylor", EmailAddress: emailAddress, CreatedAt: time.UnixMilli(1361459822000)}, models.UserLineage{UserID: 13}, userservice.ConflictOverwrite)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		userCache cache.Cache
+	}{
+		{name: "Cache is down", userCache: downCache{}},
+		{name: "Cache is up", userCache: cache.NewLRU(10, time.Minute)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Second read is served from cache when it is up
+			for range 2 {
+				user, err := userservice.GetUser(repo, tt.userCache, "13")
+				if assert.NoError(t, err) {
+					assert.Equal(t, "Grace", user.FirstName)
+					assert.Equal(t, "GraceTaylor1951@inbox.edu", user.EmailAddress)
+				}
+			}
+		})
+	}
+
+	lru := tests[1].userCache.Stats()
+	assert.Equal(t, int64(1), lru.Hits)
+	assert.Equal(t, int64(1), lru.Misses)
+
+	errTests := []struct {
+		name    string
+		userID  string
+		wantErr error
+	}{
+		{name: "Missing user", userID: "404", wantErr: userservice.ErrNotFound},
+		{name: "Non numeric id", userID: "grace", wantErr: userservice.ErrInvalidInput},
+		{name: "Negative id", userID: "-13", wantErr: userservice.ErrInvalidInput},
+	}
+
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := userservice.GetUser(repo, downCache{}, tt.userID)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+
+	_, err = userservice.GetUser(repo, downCache{}, "404")
+	assert.ErrorIs(t, err, userservice.ErrUserNotFound)
+	assert.NotErrorIs(t, err, userservice.ErrUnavailable)
+}
+
+func TestWriteUser(t *testing.T) {
+	db, err := database.NewLite(zap.NewNop(), filepath.Join(t.TempDir(), "users.db"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer db.Close()
+
+	repo := userservice.NewUserRepository(db)
+	userCache := cache.NewLRU(10, time.Minute)
+	createdAt := time.UnixMilli(1361459822000)
+
+	t.Run("Invalid user", func(t *testing.T) {
+		parentUserID := 13
+		mergedAt := createdAt.Add(-time.Hour)
+
+		err := userservice.CreateUser(repo, userCache, &models.User{ID: 13, FirstName: " ", EmailAddress: "grace", CreatedAt: createdAt, MergedAt: &mergedAt, ParentUserID: &parentUserID})
+
+		var validationErr *userservice.ValidationError
+		if assert.ErrorAs(t, err, &validationErr) {
+			assert.Equal(t, map[string]string{
+				"first_name":     "is required",
+				"last_name":      "is required",
+				"email_address":  "is not a valid email",
+				"merged_at":      "can not be before created_at",
+				"parent_user_id": "can not be same as id",
+			}, validationErr.Fields)
+		}
+	})
+
+	t.Run("Missing parent", func(t *testing.T) {
+		parentUserID := 404
+
+		err := userservice.CreateUser(repo, userCache, &models.User{ID: 13, FirstName: "Grace", LastName: "Taylor", EmailAddress: "GraceTaylor1951@inbox.edu", CreatedAt: createdAt, ParentUserID: &parentUserID})
+
+		var validationErr *userservice.ValidationError
+		if assert.ErrorAs(t, err, &validationErr) {
+			assert.Equal(t, map[string]string{"parent_user_id": "does not exist"}, validationErr.Fields)
+		}
+	})
+
+	t.Run("Written user is read again", func(t *testing.T) {
+		user := &models.User{ID: 13, FirstName: "Grace", LastName: "Taylor", EmailAddress: "GraceTaylor1951@inbox.edu", CreatedAt: createdAt}
+		err := userservice.CreateUser(repo, userCache, user)
+		assert.NoError(t, err)
+		assert.Equal(t, "GraceTaylor1951@inbox.edu", user.EmailAddress, "email address of user should not be changed")
+
+		stored, err := repo.GetUser(13)
+		if assert.NoError(t, err) {
+			assert.NotEqual(t, "GraceTaylor1951@inbox.edu", stored.EmailAddress, "email address should be encrypted")
+		}
+
+		// Cache the user, so the writes have to invalidate it
+		_, err = userservice.GetUser(repo, userCache, "13")
+		assert.NoError(t, err)
+
+		user.LastName = "Schmidt"
+		err = userservice.UpdateUser(repo, userCache, user)
+		assert.NoError(t, err)
+
+		got, err := userservice.GetUser(repo, userCache, "13")
+		if assert.NoError(t, err) {
+			assert.Equal(t, "Schmidt", got.LastName)
+		}
+
+		patched, err := userservice.PatchUser(repo, userCache, 13, func(user *models.User) error {
+			user.EmailAddress = "grace@inbox.edu"
+			return nil
+		})
+		if assert.NoError(t, err) {
+			assert.Equal(t, "Schmidt", patched.LastName)
+		}
+
+		got, err = userservice.GetUser(repo, userCache, "13")
+		if assert.NoError(t, err) {
+			assert.Equal(t, "grace@inbox.edu", got.EmailAddress)
+		}
+
+		err = userservice.DeleteUser(repo, userCache, 13)
+		assert.NoError(t, err)
+
+		got, err = userservice.GetUser(repo, userCache, "13")
+		if assert.NoError(t, err) {
+			assert.NotNil(t, got.DeletedAt)
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package userservice
+
+import (
+	"errors"
+
+	"github.com/vatsal3003/viswals/models"
+)
+
+// ValidationError is returned when the written user or the user id is invalid, Fields has the message of every invalid field
+// it is of ErrInvalidInput kind
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid user: " + models.InvalidFields(e.Fields).Error()
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalidInput
+}
+
+// invalidField will return the validation error of the field
+func invalidField(field, message string) *ValidationError {
+	return &ValidationError{Fields: map[string]string{field: message}}
+}
+
+// ValidateUser will validate every field of the written user by the rules of models.ValidateUser,
+// it returns *ValidationError with the invalid fields
+func ValidateUser(user *models.User) error {
+	var fields models.InvalidFields
+	if errors.As(models.ValidateUser(user), &fields) {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
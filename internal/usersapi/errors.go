@@ -0,0 +1,62 @@
+package usersapi
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/vatsal3003/viswals/internal/consts"
+	"github.com/vatsal3003/viswals/internal/service/userservice"
+	"github.com/vatsal3003/viswals/models"
+)
+
+// retryAfter is the seconds after which the clients can try again a request failed by unavailable database
+const retryAfter = 5
+
+// writeError will write the response of the error of users api by its kind and reports whether there was no error
+// invalid input is 400, missing user is 404, conflict is 409, unavailable database is 503 and any other error is 500
+func (api *API) writeError(w http.ResponseWriter, err error) bool {
+	var validationErr *userservice.ValidationError
+
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &validationErr):
+		api.writeFieldErrors(w, validationErr.Fields)
+	case errors.Is(err, userservice.ErrInvalidInput):
+		api.writeErrorResponse(w, http.StatusBadRequest, errorMessage(err, http.StatusBadRequest), nil)
+	case errors.Is(err, userservice.ErrNotFound):
+		api.writeErrorResponse(w, http.StatusNotFound, errorMessage(err, http.StatusNotFound), nil)
+	case errors.Is(err, userservice.ErrConflict):
+		api.writeErrorResponse(w, http.StatusConflict, errorMessage(err, http.StatusConflict), nil)
+	case errors.Is(err, userservice.ErrUnavailable):
+		api.Logger.Warn("failed to process user request, database is unavailable:" + err.Error())
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		api.writeErrorResponse(w, http.StatusServiceUnavailable, userservice.ErrUnavailable.Error(), nil)
+	default:
+		api.Logger.Error("failed to process user request:" + err.Error())
+		api.writeErrorResponse(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), nil)
+	}
+
+	return false
+}
+
+// errorMessage will return the message of the error of user service which can be shown to the clients,
+// the database errors it wraps are not shown
+func errorMessage(err error, code int) string {
+	var serviceErr *userservice.Error
+	if errors.As(err, &serviceErr) {
+		return serviceErr.Message
+	}
+	return http.StatusText(code)
+}
+
+// writeFieldErrors will reject the request with the message of every invalid field
+func (api *API) writeFieldErrors(w http.ResponseWriter, errs map[string]string) {
+	api.writeErrorResponse(w, http.StatusBadRequest, userservice.ErrInvalidInput.Error(), errs)
+}
+
+// writeErrorResponse will write the response with error status, its message and the message of every invalid field
+func (api *API) writeErrorResponse(w http.ResponseWriter, code int, message string, errs map[string]string) {
+	api.writeResponse(w, code, models.Response{Status: consts.StatusError, Message: message, Errors: errs})
+}
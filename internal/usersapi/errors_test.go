@@ -0,0 +1,127 @@
+package usersapi_test
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vatsal3003/viswals/internal/cache"
+	"github.com/vatsal3003/viswals/internal/consts"
+	"github.com/vatsal3003/viswals/internal/database"
+	"github.com/vatsal3003/viswals/internal/service/userservice"
+	"github.com/vatsal3003/viswals/internal/usersapi"
+	"github.com/vatsal3003/viswals/models"
+	"go.uber.org/zap"
+)
+
+// downRepository fails every read of users, like a database which is not reachable
+type downRepository struct {
+	userservice.UserRepository
+}
+
+func (downRepository) GetUser(userID int) (*models.User, error) {
+	return nil, driver.ErrBadConn
+}
+
+func TestErrorResponses(t *testing.T) {
+	db, err := database.NewLite(zap.NewNop(), filepath.Join(t.TempDir(), "users.db"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer db.Close()
+
+	api := usersapi.New(db, cache.NewLRU(10, time.Minute), userservice.NewHub(0), zap.NewNop())
+	err = userservice.CreateUser(api.Users, api.Cache, &models.User{ID: 13, FirstName: "Grace", LastName: "Taylor", EmailAddress: "GraceTaylor1951@inbox.edu", CreatedAt: time.UnixMilli(1361459822000)})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	down := *api
+	down.Users = downRepository{api.Users}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{userID}", api.GetUser)
+	mux.HandleFunc("GET /down/users/{userID}", down.GetUser)
+	mux.HandleFunc("POST /users", api.CreateUser)
+	mux.HandleFunc("GET /users", api.GetAllUsers)
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		body        string
+		wantCode    int
+		wantMessage string
+	}{
+		{
+			name:     "Existing user",
+			method:   http.MethodGet,
+			target:   "/users/13",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:        "Missing user",
+			method:      http.MethodGet,
+			target:      "/users/404",
+			wantCode:    http.StatusNotFound,
+			wantMessage: "user does not exist",
+		},
+		{
+			name:        "Non numeric id",
+			method:      http.MethodGet,
+			target:      "/users/grace",
+			wantCode:    http.StatusBadRequest,
+			wantMessage: "invalid input",
+		},
+		{
+			name:        "Existing user is created again",
+			method:      http.MethodPost,
+			target:      "/users",
+			body:        `{"id": 13, "first_name": "Grace", "last_name": "Taylor", "email_address": "GraceTaylor1951@inbox.edu"}`,
+			wantCode:    http.StatusConflict,
+			wantMessage: "user already exists",
+		},
+		{
+			name:        "Malformed cursor",
+			method:      http.MethodGet,
+			target:      "/users?cursor=grace",
+			wantCode:    http.StatusBadRequest,
+			wantMessage: "invalid cursor",
+		},
+		{
+			name:        "Database is down",
+			method:      http.MethodGet,
+			target:      "/down/users/404",
+			wantCode:    http.StatusServiceUnavailable,
+			wantMessage: "database is unavailable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+			var response models.Response
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+			if tt.wantCode == http.StatusOK {
+				assert.Equal(t, consts.StatusSuccess, response.Status)
+				return
+			}
+			assert.Equal(t, consts.StatusError, response.Status)
+			assert.Equal(t, tt.wantMessage, response.Message)
+			if tt.wantCode == http.StatusServiceUnavailable {
+				assert.NotEmpty(t, w.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package usersapi
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vatsal3003/viswals/internal/service/userservice"
+)
+
+// userFilter will parse the filter of GET /users from the query params
+//
+//	first_name, last_name   names matched by match (exact, prefix or contains, defaults to prefix)
+//	id                      comma separated or repeated user ids
+//	created_from            users created at or after the time, RFC 3339 or unix milliseconds
+//	created_before          users created before the time, RFC 3339 or unix milliseconds
+//	deleted, merged         users whose deleted_at or merged_at is set (true) or not set (false)
+//	parent_user_id          users merged into the parent
+//	combine                 and (default) to match all conditions, or to match any of them
+func userFilter(query url.Values) (userservice.UserFilter, error) {
+	filter := userservice.UserFilter{
+		FirstName: query.Get("first_name"),
+		LastName:  query.Get("last_name"),
+	}
+
+	var err error
+
+	filter.Match, err = userservice.ParseMatch(query.Get("match"))
+	if err != nil {
+		return filter, err
+	}
+
+	filter.Combine, err = userservice.ParseCombine(query.Get("combine"))
+	if err != nil {
+		return filter, err
+	}
+
+	for _, ids := range query["id"] {
+		for _, id := range strings.Split(ids, ",") {
+			userID, err := parseUserID("id", strings.TrimSpace(id))
+			if err != nil {
+				return filter, err
+			}
+			filter.IDs = append(filter.IDs, userID)
+		}
+	}
+
+	filter.CreatedFrom, err = parseTime("created_from", query.Get("created_from"))
+	if err != nil {
+		return filter, err
+	}
+
+	filter.CreatedBefore, err = parseTime("created_before", query.Get("created_before"))
+	if err != nil {
+		return filter, err
+	}
+
+	filter.Deleted, err = parseBool("deleted", query.Get("deleted"))
+	if err != nil {
+		return filter, err
+	}
+
+	filter.Merged, err = parseBool("merged", query.Get("merged"))
+	if err != nil {
+		return filter, err
+	}
+
+	if parentUserID := query.Get("parent_user_id"); parentUserID != "" {
+		userID, err := parseUserID("parent_user_id", parentUserID)
+		if err != nil {
+			return filter, err
+		}
+		filter.ParentUserID = &userID
+	}
+
+	return filter, nil
+}
+
+// userPage will parse the page of GET /users from the query params and report whether the total is requested
+//
+//	limit                   number of users in page, defaults to 100 and is capped by 1000
+//	cursor                  next_cursor of the previous page
+//	sort                    id (default), created_at or last_name
+//	order                   asc (default) or desc
+//	total                   true to count the users matching the filter across all pages
+func userPage(query url.Values) (userservice.Page, bool, error) {
+	page := userservice.Page{Cursor: query.Get("cursor")}
+
+	var err error
+
+	if limit := query.Get("limit"); limit != "" {
+		page.Limit, err = strconv.Atoi(limit)
+		if err != nil || page.Limit <= 0 {
+			return page, false, errors.New("invalid limit " + limit + ", expected positive integer")
+		}
+	}
+
+	page.Sort, err = userservice.ParseSort(query.Get("sort"))
+	if err != nil {
+		return page, false, err
+	}
+
+	page.Order, err = userservice.ParseOrder(query.Get("order"))
+	if err != nil {
+		return page, false, err
+	}
+
+	total, err := parseBool("total", query.Get("total"))
+	if err != nil {
+		return page, false, err
+	}
+
+	return page, total != nil && *total, nil
+}
+
+// parseUserID will parse the positive user id of param
+func parseUserID(param, value string) (int, error) {
+	userID, err := strconv.Atoi(value)
+	if err != nil || userID <= 0 {
+		return 0, errors.New("invalid " + param + " " + value + ", expected positive integer")
+	}
+	return userID, nil
+}
+
+// parseTime will parse the RFC 3339 or unix milliseconds time of param, empty value is nil
+func parseTime(param, value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		t := time.UnixMilli(millis)
+		return &t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, errors.New("invalid " + param + " " + value + ", expected RFC 3339 time or unix milliseconds")
+	}
+	return &t, nil
+}
+
+// parseBool will parse the boolean of param, empty value is nil
+func parseBool(param, value string) (*bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, errors.New("invalid " + param + " " + value + ", expected true or false")
+	}
+	return &b, nil
+}
@@ -0,0 +1,78 @@
+package usersapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/vatsal3003/viswals/internal/consts"
+	"github.com/vatsal3003/viswals/internal/service/userservice"
+	"github.com/vatsal3003/viswals/models"
+)
+
+// mergeRequest is the body of merge request
+type mergeRequest struct {
+	ParentUserID int `json:"parent_user_id"`
+}
+
+// MergeUser will merge the user into the parent user of request body
+func (api *API) MergeUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := api.pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req mergeRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&req)
+	if err != nil || req.ParentUserID <= 0 {
+		api.writeFieldErrors(w, map[string]string{"parent_user_id": "must be a positive integer"})
+		return
+	}
+	if req.ParentUserID == userID {
+		api.writeFieldErrors(w, map[string]string{"parent_user_id": "can not be same as id"})
+		return
+	}
+
+	user, err := userservice.MergeUser(api.Users, api.Cache, userID, req.ParentUserID)
+	if errors.Is(err, userservice.ErrMissingParent) {
+		api.writeFieldErrors(w, map[string]string{"parent_user_id": "does not exist"})
+		return
+	}
+	if !api.writeError(w, err) {
+		return
+	}
+
+	api.writeResponse(w, http.StatusOK, models.Response{Status: consts.StatusSuccess, Data: user})
+}
+
+// GetCanonicalUser will return the user which the user is finally merged into
+func (api *API) GetCanonicalUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := api.pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := userservice.GetCanonicalUser(api.Users, userID)
+	if !api.writeError(w, err) {
+		return
+	}
+
+	api.writeResponse(w, http.StatusOK, models.Response{Status: consts.StatusSuccess, Data: user})
+}
+
+// GetChildUsers will return every user merged into the user
+func (api *API) GetChildUsers(w http.ResponseWriter, r *http.Request) {
+	userID, ok := api.pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	users, err := userservice.GetChildUsers(api.Users, userID)
+	if !api.writeError(w, err) {
+		return
+	}
+
+	api.writeResponse(w, http.StatusOK, models.Response{Status: consts.StatusSuccess, Data: users})
+}
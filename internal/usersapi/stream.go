@@ -0,0 +1,169 @@
+package usersapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vatsal3003/viswals/internal/service/userservice"
+)
+
+const (
+	// heartbeatInterval is the interval of comments sent on idle streams, so proxies do not close them
+	heartbeatInterval = 15 * time.Second
+	// reconnectDelay is the delay in milliseconds after which the clients reconnect to a closed stream
+	reconnectDelay = 3000
+
+	// replayLimit is the largest number of stored users sent by the replay stream
+	replayLimit = 25
+	// replayDelay is the pause between two users of the replay stream
+	replayDelay = 500 * time.Millisecond
+)
+
+// StreamUsers will stream the users stored by consumer pipeline as server sent events, filtered by the query params of GET /users
+// every event has id, so the reconnecting client resumes after the event of Last-Event-ID header or last_event_id query param
+// with replay=true the stored users are replayed instead, see replayUsers
+func (api *API) StreamUsers(w http.ResponseWriter, r *http.Request) {
+	filter, err := userFilter(r.URL.Query())
+	if err != nil {
+		api.writeErrorResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	replay, err := parseBool("replay", r.URL.Query().Get("replay"))
+	if err != nil {
+		api.writeErrorResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	// flusher to send data immediately to client using Flush function
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		api.writeErrorResponse(w, http.StatusInternalServerError, "streaming unsupported", nil)
+		return
+	}
+
+	if replay != nil && *replay {
+		api.replayUsers(w, r, flusher, filter)
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	subscription, missed := api.Hub.Subscribe(lastEventID)
+	defer api.Hub.Unsubscribe(subscription)
+
+	// Set essential headers
+	w.Header().Set("Content-Type", "text/event-stream") // its mandatory for SSE
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write([]byte("retry: " + strconv.Itoa(reconnectDelay) + "\n\n"))
+	if err != nil {
+		return
+	}
+
+	for _, event := range missed {
+		if !api.writeEvent(w, filter, event) {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-subscription.Events():
+			// Subscription is closed when the client falls behind, it resumes from its last event after reconnecting
+			if !ok {
+				return
+			}
+			if !api.writeEvent(w, filter, event) {
+				return
+			}
+		case <-heartbeat.C:
+			_, err = w.Write([]byte(": heartbeat\n\n"))
+			if err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// replayUsers will send the first stored users matching the filter one by one and end the stream with END data,
+// the page is read from the query params of GET /users and has at most replayLimit users
+func (api *API) replayUsers(w http.ResponseWriter, r *http.Request, flusher http.Flusher, filter userservice.UserFilter) {
+	page, _, err := userPage(r.URL.Query())
+	if err != nil {
+		api.writeErrorResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if page.Limit == 0 || page.Limit > replayLimit {
+		page.Limit = replayLimit
+	}
+
+	users, _, err := userservice.GetAllUsers(api.Users, filter, page)
+	if !api.writeError(w, err) {
+		return
+	}
+
+	// Set essential headers
+	w.Header().Set("Content-Type", "text/event-stream") // its mandatory for SSE
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+
+	for i, user := range users {
+		if i > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(replayDelay):
+			}
+		}
+
+		data, err := json.Marshal(user)
+		if err != nil {
+			api.Logger.Error("failed to marshal user:" + err.Error())
+			return
+		}
+
+		_, err = w.Write([]byte("data: " + string(data) + "\n\n"))
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+
+	_, err = w.Write([]byte("data: END\n\n"))
+	if err != nil {
+		return
+	}
+	flusher.Flush()
+}
+
+// writeEvent will write the event if its user matches the filter, it reports whether the stream can go on
+func (api *API) writeEvent(w http.ResponseWriter, filter userservice.UserFilter, event userservice.Event) bool {
+	if !filter.Matches(event.User) {
+		return true
+	}
+
+	data, err := json.Marshal(event.User)
+	if err != nil {
+		api.Logger.Error("failed to marshal user:" + err.Error())
+		return false
+	}
+
+	_, err = w.Write([]byte("id: " + event.ID + "\ndata: " + string(data) + "\n\n"))
+	return err == nil
+}
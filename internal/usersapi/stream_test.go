@@ -0,0 +1,181 @@
+package usersapi_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vatsal3003/viswals/internal/cache"
+	"github.com/vatsal3003/viswals/internal/database"
+	"github.com/vatsal3003/viswals/internal/service/userservice"
+	"github.com/vatsal3003/viswals/internal/usersapi"
+	"github.com/vatsal3003/viswals/models"
+	"go.uber.org/zap"
+)
+
+// event is a server sent event read from the users stream
+type event struct {
+	id   string
+	user models.User
+}
+
+// readEvent will read the next event of stream, skipping the comments and the retry field
+func readEvent(t *testing.T, reader *bufio.Reader) event {
+	var e event
+	for {
+		line, err := reader.ReadString('\n')
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			e.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e.user))
+		case line == "" && e.id != "":
+			return e
+		}
+	}
+}
+
+// openStream will connect to the users stream and wait until it is subscribed to the hub
+func openStream(t *testing.T, url, lastEventID string) (*bufio.Reader, func()) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(res.Body)
+	retry, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(retry, "retry: "))
+
+	return reader, func() { res.Body.Close() }
+}
+
+func TestStreamUsers(t *testing.T) {
+	hub := userservice.NewHub(0)
+	api := &usersapi.API{Hub: hub, Logger: zap.NewNop()}
+
+	server := httptest.NewServer(http.HandlerFunc(api.StreamUsers))
+	defer server.Close()
+
+	reader, closeStream := openStream(t, server.URL+"?last_name=tay", "")
+
+	hub.Publish(
+		&models.User{ID: 8, FirstName: "Emily", LastName: "Schmidt"},
+		&models.User{ID: 13, FirstName: "Grace", LastName: "Taylor"},
+		&models.User{ID: 31, FirstName: "Ann", LastName: "Schmidt"},
+		&models.User{ID: 42, FirstName: "Ann", LastName: "Taylor"},
+	)
+
+	first := readEvent(t, reader)
+	assert.Equal(t, 13, first.user.ID)
+	assert.Equal(t, "Grace", first.user.FirstName)
+	assert.Equal(t, 42, readEvent(t, reader).user.ID, "users not matching the filter should be skipped")
+	closeStream()
+
+	// Reconnecting client gets the events it missed after its last event
+	reader, closeStream = openStream(t, server.URL, first.id)
+	defer closeStream()
+
+	assert.Equal(t, 31, readEvent(t, reader).user.ID)
+	assert.Equal(t, 42, readEvent(t, reader).user.ID)
+
+	hub.Publish(&models.User{ID: 55, FirstName: "Noah", LastName: "Brown"})
+	assert.Equal(t, 55, readEvent(t, reader).user.ID)
+}
+
+func TestReplayUsers(t *testing.T) {
+	db, err := database.NewLite(zap.NewNop(), filepath.Join(t.TempDir(), "users.db"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer db.Close()
+
+	api := usersapi.New(db, cache.NewLRU(10, time.Minute), userservice.NewHub(0), zap.NewNop())
+
+	createdAt := time.UnixMilli(1361459822000).UTC()
+	for _, user := range []*models.User{
+		{ID: 8, FirstName: "Emily", LastName: "Schmidt", EmailAddress: "EmilySchmidt@gmail.edu", CreatedAt: createdAt},
+		{ID: 13, FirstName: "Grace", LastName: "Taylor", EmailAddress: "GraceTaylor1951@inbox.edu", CreatedAt: createdAt},
+		{ID: 31, FirstName: "Ann", LastName: "Schmidt", EmailAddress: "AnnSchmidt@gmail.edu", CreatedAt: createdAt},
+		{ID: 42, FirstName: "Ann", LastName: "Taylor", EmailAddress: "AnnTaylor@gmail.edu", CreatedAt: createdAt},
+	} {
+		if !assert.NoError(t, userservice.CreateUser(api.Users, api.Cache, user)) {
+			t.FailNow()
+		}
+	}
+
+	tests := []struct {
+		name     string
+		target   string
+		wantCode int
+		wantData []string
+	}{
+		{
+			name:     "Stored users are replayed",
+			target:   "/users/sse?replay=true&last_name=schmidt",
+			wantCode: http.StatusOK,
+			wantData: []string{"8", "31", "END"},
+		},
+		{
+			name:     "Replay is limited",
+			target:   "/users/sse?replay=true&limit=1&sort=id&order=desc",
+			wantCode: http.StatusOK,
+			wantData: []string{"42", "END"},
+		},
+		{
+			name:     "Invalid replay",
+			target:   "/users/sse?replay=yes",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			api.StreamUsers(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			if tt.wantData == nil {
+				return
+			}
+
+			// Replay stream ends by itself after the END data
+			var data []string
+			for _, line := range strings.Split(w.Body.String(), "\n") {
+				value, ok := strings.CutPrefix(line, "data: ")
+				if !ok {
+					continue
+				}
+				if value != "END" {
+					var user models.User
+					assert.NoError(t, json.Unmarshal([]byte(value), &user))
+					value = strconv.Itoa(user.ID)
+				}
+				data = append(data, value)
+			}
+			assert.Equal(t, tt.wantData, data)
+		})
+	}
+}
@@ -2,11 +2,9 @@ package usersapi
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
-	"strconv"
-	"time"
 
+	"github.com/vatsal3003/viswals/internal/cache"
 	"github.com/vatsal3003/viswals/internal/consts"
 	"github.com/vatsal3003/viswals/internal/database"
 	"github.com/vatsal3003/viswals/internal/service/userservice"
@@ -15,13 +13,20 @@ import (
 )
 
 type API struct {
-	DB     *database.Database
+	DB    *database.Database
+	Users userservice.UserRepository
+	Cache cache.Cache
+	// Hub broadcasts the users stored by consumer pipeline to the users stream
+	Hub    *userservice.Hub
 	Logger *zap.Logger
 }
 
-func New(db *database.Database, logger *zap.Logger) *API {
+func New(db *database.Database, userCache cache.Cache, hub *userservice.Hub, logger *zap.Logger) *API {
 	return &API{
 		DB:     db,
+		Users:  userservice.NewUserRepository(db),
+		Cache:  userCache,
+		Hub:    hub,
 		Logger: logger,
 	}
 }
@@ -29,34 +34,51 @@ func New(db *database.Database, logger *zap.Logger) *API {
 func (api *API) InitRoutes() {
 	http.HandleFunc("GET /users", api.GetAllUsers)
 	http.HandleFunc("GET /users/{userID}", api.GetUser)
-	http.HandleFunc("GET /users/sse", api.GetAllUsersSSE)
+	http.HandleFunc("GET /users/sse", api.StreamUsers)
+	http.HandleFunc("POST /users", api.CreateUser)
+	http.HandleFunc("PUT /users/{userID}", api.UpdateUser)
+	http.HandleFunc("PATCH /users/{userID}", api.PatchUser)
+	http.HandleFunc("DELETE /users/{userID}", api.DeleteUser)
+	http.HandleFunc("POST /users/{userID}/merge", api.MergeUser)
+	http.HandleFunc("GET /users/{userID}/canonical", api.GetCanonicalUser)
+	http.HandleFunc("GET /users/{userID}/children", api.GetChildUsers)
 }
 
 func (api *API) GetAllUsers(w http.ResponseWriter, r *http.Request) {
-	fname := r.URL.Query().Get("first_name")
-	lname := r.URL.Query().Get("last_name")
-
-	filters := make(map[string]string)
-
-	if fname != "" {
-		filters["first_name"] = fname
-	} else if lname != "" {
-		filters["last_name"] = lname
+	filter, err := userFilter(r.URL.Query())
+	if err != nil {
+		api.writeErrorResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
 	}
 
-	users, err := userservice.GetAllUsers(api.DB, filters)
+	page, withTotal, err := userPage(r.URL.Query())
 	if err != nil {
-		api.Logger.Error("failed to get all users from database:" + err.Error())
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		api.writeErrorResponse(w, http.StatusBadRequest, err.Error(), nil)
 		return
 	}
 
+	users, next, err := userservice.GetAllUsers(api.Users, filter, page)
+	if !api.writeError(w, err) {
+		return
+	}
+
+	var total *int
+	if withTotal {
+		count, err := userservice.CountUsers(api.Users, filter)
+		if !api.writeError(w, err) {
+			return
+		}
+		total = &count
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
 	err = json.NewEncoder(w).Encode(models.Response{
-		Status: consts.StatusSuccess,
-		Data:   users,
+		Status:     consts.StatusSuccess,
+		Data:       users,
+		NextCursor: next,
+		Total:      total,
 	})
 	if err != nil {
 		api.Logger.Error("failed to encode users to JSON: " + err.Error())
@@ -68,10 +90,8 @@ func (api *API) GetAllUsers(w http.ResponseWriter, r *http.Request) {
 func (api *API) GetUser(w http.ResponseWriter, r *http.Request) {
 	userID := r.PathValue("userID")
 
-	user, err := userservice.GetUser(api.DB, userID)
-	if err != nil {
-		api.Logger.Error("failed to get user from database:" + err.Error())
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	user, err := userservice.GetUser(api.Users, api.Cache, userID)
+	if !api.writeError(w, err) {
 		return
 	}
 
@@ -87,51 +107,3 @@ func (api *API) GetUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
-
-func (api *API) GetAllUsersSSE(w http.ResponseWriter, r *http.Request) {
-	limit := 25
-
-	qLimit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
-
-	if qLimit < limit {
-		limit = qLimit
-	}
-
-	// Set essential headers
-	w.Header().Set("Content-Type", "text/event-stream") // its mandatory for SSE
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-
-	// flusher to send data immediately to client using Flush function
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "Streaming Unsupported", http.StatusInternalServerError)
-		return
-	}
-
-	users, err := userservice.GetAllUsers(api.DB, nil)
-	if err != nil {
-		api.Logger.Error("failed to get all users from database:" + err.Error())
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
-
-	if limit > len(users) {
-		limit = len(users)
-	}
-
-	for i := 0; i < limit; i++ {
-		data, err := json.Marshal(users[i])
-		if err != nil {
-			api.Logger.Error("failed to marshal user:" + err.Error())
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
-		fmt.Fprint(w, "data: "+string(data)+"\n\n")
-		flusher.Flush()
-		time.Sleep(500 * time.Millisecond)
-	}
-
-	fmt.Fprint(w, "data: END\n\n")
-	flusher.Flush()
-}
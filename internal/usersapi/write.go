@@ -0,0 +1,214 @@
+package usersapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vatsal3003/viswals/internal/consts"
+	"github.com/vatsal3003/viswals/internal/service/userservice"
+	"github.com/vatsal3003/viswals/models"
+)
+
+// maxBodySize is the largest request body of write api
+const maxBodySize = 1 << 20
+
+// CreateUser will create the user of request body, created_at defaults to now
+func (api *API) CreateUser(w http.ResponseWriter, r *http.Request) {
+	user := &models.User{CreatedAt: time.Now().UTC().Truncate(time.Millisecond)}
+
+	ok := api.decodeUser(w, r, user)
+	if !ok {
+		return
+	}
+
+	err := userservice.CreateUser(api.Users, api.Cache, user)
+	if !api.writeError(w, err) {
+		return
+	}
+
+	w.Header().Set("Location", "/users/"+strconv.Itoa(user.ID))
+	api.writeResponse(w, http.StatusCreated, models.Response{Status: consts.StatusSuccess, Data: user})
+}
+
+// UpdateUser will replace the user with the request body, fields which are not set are cleared
+func (api *API) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := api.pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	user := &models.User{ID: userID}
+
+	ok = api.decodeUser(w, r, user)
+	if !ok {
+		return
+	}
+	if user.ID != userID {
+		api.writeFieldErrors(w, map[string]string{"id": "can not be changed"})
+		return
+	}
+
+	err := userservice.UpdateUser(api.Users, api.Cache, user)
+	if !api.writeError(w, err) {
+		return
+	}
+
+	api.writeResponse(w, http.StatusOK, models.Response{Status: consts.StatusSuccess, Data: user})
+}
+
+// PatchUser will change only the fields set in the request body, null clears the optional fields
+func (api *API) PatchUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := api.pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	fields, ok := api.decodeFields(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := userservice.PatchUser(api.Users, api.Cache, userID, func(user *models.User) error {
+		errs := applyUserFields(user, fields)
+		if user.ID != userID {
+			errs["id"] = "can not be changed"
+		}
+		if len(errs) != 0 {
+			return &userservice.ValidationError{Fields: errs}
+		}
+		return nil
+	})
+	if !api.writeError(w, err) {
+		return
+	}
+
+	api.writeResponse(w, http.StatusOK, models.Response{Status: consts.StatusSuccess, Data: user})
+}
+
+// DeleteUser will soft delete the user by setting its deleted_at
+func (api *API) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := api.pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	err := userservice.DeleteUser(api.Users, api.Cache, userID)
+	if !api.writeError(w, err) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pathUserID will parse the user id of path, it writes the error response and reports false when it is invalid
+func (api *API) pathUserID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := parseUserID("user id", r.PathValue("userID"))
+	if err != nil {
+		api.writeFieldErrors(w, map[string]string{"id": "must be a positive integer"})
+		return 0, false
+	}
+	return userID, true
+}
+
+// decodeFields will decode the json object of request body by its fields, it writes the error response and reports false when it is invalid
+func (api *API) decodeFields(w http.ResponseWriter, r *http.Request) (map[string]json.RawMessage, bool) {
+	var fields map[string]json.RawMessage
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&fields)
+	if err != nil || fields == nil {
+		api.writeFieldErrors(w, map[string]string{"body": "must be a json object of user"})
+		return nil, false
+	}
+	return fields, true
+}
+
+// decodeUser will set the fields of request body on the user, it writes the error response and reports false when they are invalid
+func (api *API) decodeUser(w http.ResponseWriter, r *http.Request, user *models.User) bool {
+	fields, ok := api.decodeFields(w, r)
+	if !ok {
+		return false
+	}
+
+	errs := applyUserFields(user, fields)
+	if len(errs) != 0 {
+		api.writeFieldErrors(w, errs)
+		return false
+	}
+	return true
+}
+
+// applyUserFields will set the json fields on the user and return the message of every field which can not be set
+func applyUserFields(user *models.User, fields map[string]json.RawMessage) map[string]string {
+	errs := make(map[string]string)
+
+	for field, value := range fields {
+		var err error
+		switch field {
+		case "id":
+			err = json.Unmarshal(value, &user.ID)
+		case "first_name":
+			err = unmarshalRequired(value, &user.FirstName)
+		case "last_name":
+			err = unmarshalRequired(value, &user.LastName)
+		case "email_address":
+			err = unmarshalRequired(value, &user.EmailAddress)
+		case "created_at":
+			err = unmarshalRequired(value, &user.CreatedAt)
+		case "deleted_at":
+			user.DeletedAt = nil
+			err = json.Unmarshal(value, &user.DeletedAt)
+		case "merged_at":
+			user.MergedAt = nil
+			err = json.Unmarshal(value, &user.MergedAt)
+		case "parent_user_id":
+			user.ParentUserID = nil
+			err = json.Unmarshal(value, &user.ParentUserID)
+		default:
+			errs[field] = "is not a field of user"
+			continue
+		}
+
+		if err != nil {
+			errs[field] = fieldMessage(field)
+		}
+	}
+
+	return errs
+}
+
+// unmarshalRequired will unmarshal the value of a field which can not be null
+func unmarshalRequired(value json.RawMessage, v any) error {
+	if string(value) == "null" {
+		return errors.New("value is null")
+	}
+	return json.Unmarshal(value, v)
+}
+
+// fieldMessage is the message of the field whose value has wrong type
+func fieldMessage(field string) string {
+	switch field {
+	case "id":
+		return "must be an integer"
+	case "parent_user_id":
+		return "must be an integer or null"
+	case "created_at":
+		return "must be an RFC 3339 time"
+	case "deleted_at", "merged_at":
+		return "must be an RFC 3339 time or null"
+	default:
+		return "must be a string"
+	}
+}
+
+// writeResponse will write the response as json with the status code
+func (api *API) writeResponse(w http.ResponseWriter, code int, response models.Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		api.Logger.Error("failed to encode response to JSON: " + err.Error())
+	}
+}
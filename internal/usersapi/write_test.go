@@ -0,0 +1,191 @@
+package usersapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vatsal3003/viswals/internal/cache"
+	"github.com/vatsal3003/viswals/internal/consts"
+	"github.com/vatsal3003/viswals/internal/database"
+	"github.com/vatsal3003/viswals/internal/service/userservice"
+	"github.com/vatsal3003/viswals/internal/usersapi"
+	"github.com/vatsal3003/viswals/models"
+	"go.uber.org/zap"
+)
+
+// userResponse is the response of write api with the written user
+type userResponse struct {
+	Status string            `json:"status"`
+	Data   models.User       `json:"data"`
+	Errors map[string]string `json:"errors"`
+}
+
+func TestWriteUsers(t *testing.T) {
+	db, err := database.NewLite(zap.NewNop(), filepath.Join(t.TempDir(), "users.db"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer db.Close()
+
+	api := usersapi.New(db, cache.NewLRU(10, time.Minute), userservice.NewHub(0), zap.NewNop())
+
+	createdAt := time.UnixMilli(1361459822000).UTC()
+	changedAt := createdAt.Add(time.Hour)
+	parentUserID := 8
+	for _, user := range []*models.User{
+		{ID: 8, FirstName: "Hanah", LastName: "Schmidt", EmailAddress: "Hanah_Schmidt1965@gmail.edu", CreatedAt: createdAt},
+		{ID: 13, FirstName: "Grace", LastName: "Taylor", EmailAddress: "GraceTaylor1951@inbox.edu", CreatedAt: createdAt, DeletedAt: &changedAt, MergedAt: &changedAt, ParentUserID: &parentUserID},
+	} {
+		err = userservice.CreateUser(api.Users, api.Cache, user)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{userID}", api.GetUser)
+	mux.HandleFunc("POST /users", api.CreateUser)
+	mux.HandleFunc("PUT /users/{userID}", api.UpdateUser)
+	mux.HandleFunc("PATCH /users/{userID}", api.PatchUser)
+	mux.HandleFunc("DELETE /users/{userID}", api.DeleteUser)
+
+	// Every request changes the users for the next ones, so the requests are made in order
+	tests := []struct {
+		name         string
+		method       string
+		target       string
+		body         string
+		wantCode     int
+		wantErrors   map[string]string
+		wantLocation string
+		check        func(t *testing.T, user models.User)
+	}{
+		{
+			name:         "Create user",
+			method:       http.MethodPost,
+			target:       "/users",
+			body:         `{"id": 31, "first_name": "Emily", "last_name": "Tamm", "email_address": "EmilyTamm@gmail.edu"}`,
+			wantCode:     http.StatusCreated,
+			wantLocation: "/users/31",
+			check: func(t *testing.T, user models.User) {
+				assert.Equal(t, "EmilyTamm@gmail.edu", user.EmailAddress)
+				assert.False(t, user.CreatedAt.IsZero(), "created_at should default to now")
+			},
+		},
+		{
+			name:     "Patch keeps the absent fields",
+			method:   http.MethodPatch,
+			target:   "/users/13",
+			body:     `{"first_name": "Ann"}`,
+			wantCode: http.StatusOK,
+			check: func(t *testing.T, user models.User) {
+				assert.Equal(t, "Ann", user.FirstName)
+				assert.Equal(t, "Taylor", user.LastName)
+				assert.NotNil(t, user.DeletedAt)
+				assert.NotNil(t, user.MergedAt)
+				assert.Equal(t, &parentUserID, user.ParentUserID)
+			},
+		},
+		{
+			name:     "Patch clears the null fields",
+			method:   http.MethodPatch,
+			target:   "/users/13",
+			body:     `{"deleted_at": null}`,
+			wantCode: http.StatusOK,
+			check: func(t *testing.T, user models.User) {
+				assert.Equal(t, "Ann", user.FirstName)
+				assert.Nil(t, user.DeletedAt)
+				assert.NotNil(t, user.MergedAt)
+				assert.Equal(t, &parentUserID, user.ParentUserID)
+			},
+		},
+		{
+			name:       "Patch can not clear the required fields",
+			method:     http.MethodPatch,
+			target:     "/users/13",
+			body:       `{"first_name": null, "parent_user_id": "8", "age": 42}`,
+			wantCode:   http.StatusBadRequest,
+			wantErrors: map[string]string{"first_name": "must be a string", "parent_user_id": "must be an integer or null", "age": "is not a field of user"},
+		},
+		{
+			name:       "Patch can not change the id",
+			method:     http.MethodPatch,
+			target:     "/users/13",
+			body:       `{"id": 14}`,
+			wantCode:   http.StatusBadRequest,
+			wantErrors: map[string]string{"id": "can not be changed"},
+		},
+		{
+			name:       "Put can not change the id",
+			method:     http.MethodPut,
+			target:     "/users/13",
+			body:       `{"id": 14, "first_name": "Grace", "last_name": "Taylor", "email_address": "GraceTaylor1951@inbox.edu", "created_at": "2013-02-21T15:17:02Z"}`,
+			wantCode:   http.StatusBadRequest,
+			wantErrors: map[string]string{"id": "can not be changed"},
+		},
+		{
+			name:     "Put clears the omitted fields",
+			method:   http.MethodPut,
+			target:   "/users/13",
+			body:     `{"first_name": "Grace", "last_name": "Taylor", "email_address": "GraceTaylor1951@inbox.edu", "created_at": "2013-02-21T15:17:02Z"}`,
+			wantCode: http.StatusOK,
+			check: func(t *testing.T, user models.User) {
+				assert.Equal(t, 13, user.ID)
+				assert.Equal(t, "Grace", user.FirstName)
+				assert.Nil(t, user.DeletedAt)
+				assert.Nil(t, user.MergedAt)
+				assert.Nil(t, user.ParentUserID)
+			},
+		},
+		{
+			name:     "Put of missing user",
+			method:   http.MethodPut,
+			target:   "/users/404",
+			body:     `{"first_name": "Grace", "last_name": "Taylor", "email_address": "GraceTaylor1951@inbox.edu", "created_at": "2013-02-21T15:17:02Z"}`,
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Delete user",
+			method:   http.MethodDelete,
+			target:   "/users/31",
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "Deleted user is kept with deleted_at",
+			method:   http.MethodGet,
+			target:   "/users/31",
+			wantCode: http.StatusOK,
+			check: func(t *testing.T, user models.User) {
+				assert.NotNil(t, user.DeletedAt)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, tt.wantLocation, w.Header().Get("Location"))
+			if tt.wantCode == http.StatusNoContent {
+				assert.Empty(t, w.Body.String())
+				return
+			}
+
+			var response userResponse
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+			assert.Equal(t, tt.wantErrors, response.Errors)
+			if tt.check != nil {
+				assert.Equal(t, consts.StatusSuccess, response.Status)
+				tt.check(t, response.Data)
+			}
+		})
+	}
+}
@@ -5,15 +5,19 @@ import (
 	"time"
 )
 
-func MillisToTime(ms string) *time.Time {
+// MillisToTime converts unix milliseconds to time, it returns nil time for -1
+func MillisToTime(ms string) (*time.Time, error) {
 	// if it is -1 then set to nil
 	if ms == "-1" {
-		return nil
+		return nil, nil
 	}
 
 	// convert milliseconds to timestamp
-	millis, _ := strconv.Atoi(ms)
-	t := time.Unix(0, int64(millis)*int64(time.Millisecond))
+	millis, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	t := time.UnixMilli(millis)
 
-	return &t
+	return &t, nil
 }
@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP TABLE IF EXISTS user_lineage;
+
+COMMIT;
\ No newline at end of file
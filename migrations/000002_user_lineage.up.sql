@@ -0,0 +1,17 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS user_lineage (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    schema_version INTEGER NOT NULL,
+    source TEXT,
+    line INTEGER,
+    batch_id TEXT,
+    published_at TIMESTAMPTZ,
+    consumed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS user_lineage_user_id_idx ON user_lineage (user_id);
+CREATE INDEX IF NOT EXISTS user_lineage_batch_id_idx ON user_lineage (batch_id);
+
+COMMIT;
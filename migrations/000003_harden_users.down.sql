@@ -0,0 +1,16 @@
+BEGIN;
+
+DROP INDEX IF EXISTS users_parent_user_id_idx;
+DROP INDEX IF EXISTS users_last_name_trgm_idx;
+DROP INDEX IF EXISTS users_first_name_trgm_idx;
+
+ALTER TABLE users
+    DROP CONSTRAINT IF EXISTS users_parent_user_id_fkey,
+    DROP CONSTRAINT IF EXISTS users_merged_has_parent,
+    DROP CONSTRAINT IF EXISTS users_merged_after_created,
+    DROP CONSTRAINT IF EXISTS users_deleted_after_created,
+    DROP CONSTRAINT IF EXISTS users_parent_not_self,
+    DROP CONSTRAINT IF EXISTS users_id_positive,
+    DROP CONSTRAINT IF EXISTS users_required_fields;
+
+COMMIT;
@@ -0,0 +1,27 @@
+BEGIN;
+
+-- Trigram indexes serve the ILIKE searches on names
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Checks mirror the validation of csv rows, they are added without checking the existing rows,
+-- as those were loaded without these rules, so one legacy row can not fail the migration.
+-- New and updated rows are checked, and 000005 validates the checks the existing rows comply with
+ALTER TABLE users
+    ADD CONSTRAINT users_required_fields CHECK (first_name IS NOT NULL AND last_name IS NOT NULL AND email_address IS NOT NULL AND created_at IS NOT NULL) NOT VALID,
+    ADD CONSTRAINT users_id_positive CHECK (id > 0) NOT VALID,
+    ADD CONSTRAINT users_parent_not_self CHECK (parent_user_id <> id) NOT VALID,
+    ADD CONSTRAINT users_deleted_after_created CHECK (deleted_at >= created_at) NOT VALID,
+    ADD CONSTRAINT users_merged_after_created CHECK (merged_at >= created_at) NOT VALID,
+    ADD CONSTRAINT users_merged_has_parent CHECK (merged_at IS NULL OR parent_user_id IS NOT NULL) NOT VALID;
+
+-- The parent is checked at commit, so a batch can store children before their parents
+-- existing rows are not validated, as their parents may never have been ingested
+ALTER TABLE users
+    ADD CONSTRAINT users_parent_user_id_fkey FOREIGN KEY (parent_user_id) REFERENCES users (id)
+    DEFERRABLE INITIALLY DEFERRED NOT VALID;
+
+CREATE INDEX IF NOT EXISTS users_first_name_trgm_idx ON users USING GIN (first_name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS users_last_name_trgm_idx ON users USING GIN (last_name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS users_parent_user_id_idx ON users (parent_user_id);
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP INDEX IF EXISTS users_last_name_id_idx;
+DROP INDEX IF EXISTS users_created_at_id_idx;
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+
+-- Keyset pagination of GET /users seeks by the sort column and id
+CREATE INDEX IF NOT EXISTS users_created_at_id_idx ON users (created_at, id);
+CREATE INDEX IF NOT EXISTS users_last_name_id_idx ON users (last_name, id);
+
+COMMIT;
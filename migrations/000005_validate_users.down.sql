@@ -0,0 +1,10 @@
+BEGIN;
+
+-- Validated constraints are kept, as 000003 down drops them
+ALTER TABLE users
+    ALTER COLUMN first_name DROP NOT NULL,
+    ALTER COLUMN last_name DROP NOT NULL,
+    ALTER COLUMN email_address DROP NOT NULL,
+    ALTER COLUMN created_at DROP NOT NULL;
+
+COMMIT;
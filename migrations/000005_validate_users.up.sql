@@ -0,0 +1,29 @@
+BEGIN;
+
+-- Validates the constraints of 000003 which the existing rows comply with, a constraint violated by a legacy row
+-- is left not valid with a warning instead of failing the migration, it is validated by hand once the rows are fixed
+DO $$
+DECLARE
+    invalid_constraint text;
+BEGIN
+    FOR invalid_constraint IN
+        SELECT conname FROM pg_constraint WHERE conrelid = 'users'::regclass AND NOT convalidated ORDER BY conname
+    LOOP
+        BEGIN
+            EXECUTE format('ALTER TABLE users VALIDATE CONSTRAINT %I', invalid_constraint);
+        EXCEPTION WHEN check_violation OR foreign_key_violation THEN
+            RAISE WARNING 'existing users violate %, it is left not valid: %', invalid_constraint, SQLERRM;
+        END;
+    END LOOP;
+
+    -- Valid check lets postgres set the required columns NOT NULL without scanning the table
+    IF EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = 'users'::regclass AND conname = 'users_required_fields' AND convalidated) THEN
+        ALTER TABLE users
+            ALTER COLUMN first_name SET NOT NULL,
+            ALTER COLUMN last_name SET NOT NULL,
+            ALTER COLUMN email_address SET NOT NULL,
+            ALTER COLUMN created_at SET NOT NULL;
+    END IF;
+END $$;
+
+COMMIT;
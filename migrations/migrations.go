@@ -0,0 +1,8 @@
+package migrations
+
+import "embed"
+
+// FS contains the migration scripts, they are embedded so the binaries do not need the scripts on disk
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,43 @@
+package migrations_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/stretchr/testify/assert"
+	"github.com/vatsal3003/viswals/migrations"
+)
+
+func TestMigrations(t *testing.T) {
+	source, err := iofs.New(migrations.FS, ".")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer source.Close()
+
+	// Every version has both up and down script, and versions are numbered without gaps
+	version, err := source.First()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), version)
+
+	for {
+		up, _, err := source.ReadUp(version)
+		if assert.NoError(t, err, "version %d has no up script", version) {
+			up.Close()
+		}
+		down, _, err := source.ReadDown(version)
+		if assert.NoError(t, err, "version %d has no down script", version) {
+			down.Close()
+		}
+
+		next, err := source.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, version+1, next)
+		version = next
+	}
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// UserLineage is the origin of a consumed user
+type UserLineage struct {
+	UserID        int       `json:"user_id"`
+	SchemaVersion int       `json:"schema_version"`
+	Source        string    `json:"source"`
+	Line          int       `json:"line"`
+	BatchID       string    `json:"batch_id"`
+	PublishedAt   time.Time `json:"published_at"`
+}
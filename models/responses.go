@@ -3,4 +3,12 @@ package models
 type Response struct {
 	Status string      `json:"status"`
 	Data   interface{} `json:"data,omitempty"`
+	// Message tells why the request failed, e.g. "user does not exist", it is set only with error status
+	Message string `json:"message,omitempty"`
+	// Errors has the message of every invalid field of a rejected request
+	Errors map[string]string `json:"errors,omitempty"`
+	// NextCursor is the cursor of next page of a paginated list, it is empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Total is the number of listed items across all pages, it is set only when requested
+	Total *int `json:"total,omitempty"`
 }
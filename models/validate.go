@@ -0,0 +1,89 @@
+package models
+
+import (
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	// minTimestamp and maxClockSkew define the sane range of user timestamps
+	minTimestamp = time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)
+	maxClockSkew = 24 * time.Hour
+)
+
+// InvalidFields has the message of every invalid field of user by the field name
+type InvalidFields map[string]string
+
+func (f InvalidFields) Error() string {
+	fields := make([]string, 0, len(f))
+	for field, message := range f {
+		fields = append(fields, field+" "+message)
+	}
+	slices.Sort(fields)
+	return strings.Join(fields, ", ")
+}
+
+// ValidateUser will validate every field of the user, the same rules are used for csv rows and written users
+// it returns InvalidFields with the invalid fields
+func ValidateUser(user *User) error {
+	fields := make(InvalidFields)
+
+	if user.ID <= 0 {
+		fields["id"] = "must be a positive integer"
+	}
+
+	if strings.TrimSpace(user.FirstName) == "" {
+		fields["first_name"] = "is required"
+	}
+	if strings.TrimSpace(user.LastName) == "" {
+		fields["last_name"] = "is required"
+	}
+
+	address, err := mail.ParseAddress(user.EmailAddress)
+	if err != nil || address.Address != user.EmailAddress {
+		fields["email_address"] = "is not a valid email"
+	}
+
+	if user.CreatedAt.IsZero() {
+		fields["created_at"] = "is required"
+	} else {
+		validateTimestamp(fields, "created_at", user.CreatedAt)
+	}
+
+	if user.DeletedAt != nil {
+		validateTimestamp(fields, "deleted_at", *user.DeletedAt)
+		if user.DeletedAt.Before(user.CreatedAt) {
+			fields["deleted_at"] = "can not be before created_at"
+		}
+	}
+
+	if user.MergedAt != nil {
+		validateTimestamp(fields, "merged_at", *user.MergedAt)
+		if user.ParentUserID == nil {
+			fields["merged_at"] = "can only be set with parent_user_id"
+		} else if user.MergedAt.Before(user.CreatedAt) {
+			fields["merged_at"] = "can not be before created_at"
+		}
+	}
+
+	if user.ParentUserID != nil {
+		if *user.ParentUserID <= 0 {
+			fields["parent_user_id"] = "must be a positive integer"
+		} else if *user.ParentUserID == user.ID {
+			fields["parent_user_id"] = "can not be same as id"
+		}
+	}
+
+	if len(fields) != 0 {
+		return fields
+	}
+	return nil
+}
+
+func validateTimestamp(fields InvalidFields, field string, t time.Time) {
+	if !t.After(minTimestamp) || t.After(time.Now().Add(maxClockSkew)) {
+		fields[field] = "is out of range"
+	}
+}
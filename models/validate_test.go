@@ -0,0 +1,124 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vatsal3003/viswals/models"
+)
+
+func TestValidateUser(t *testing.T) {
+	createdAt := time.UnixMilli(1361459822000)
+	before := createdAt.Add(-time.Hour)
+	after := createdAt.Add(time.Hour)
+	future := time.Now().Add(48 * time.Hour)
+	parentUserID := 118437
+	invalidParentUserID := 0
+	sameParentUserID := 13
+
+	valid := func() models.User {
+		return models.User{
+			ID:           13,
+			FirstName:    "Grace",
+			LastName:     "Taylor",
+			EmailAddress: "GraceTaylor1951@inbox.edu",
+			CreatedAt:    createdAt,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(user *models.User)
+		wantErr string
+	}{
+		{
+			name:   "Valid user",
+			modify: func(user *models.User) {},
+		},
+		{
+			name: "Valid merged user",
+			modify: func(user *models.User) {
+				user.MergedAt = &after
+				user.ParentUserID = &parentUserID
+			},
+		},
+		{
+			name:    "Invalid id",
+			modify:  func(user *models.User) { user.ID = 0 },
+			wantErr: "id must be a positive integer",
+		},
+		{
+			name:    "Invalid parent user id",
+			modify:  func(user *models.User) { user.ParentUserID = &invalidParentUserID },
+			wantErr: "parent_user_id must be a positive integer",
+		},
+		{
+			name:    "Parent user id same as id",
+			modify:  func(user *models.User) { user.ParentUserID = &sameParentUserID },
+			wantErr: "parent_user_id can not be same as id",
+		},
+		{
+			name:    "Blank names",
+			modify:  func(user *models.User) { user.FirstName, user.LastName = " ", "" },
+			wantErr: "first_name is required, last_name is required",
+		},
+		{
+			name:    "Invalid email",
+			modify:  func(user *models.User) { user.EmailAddress = "grace.inbox.edu" },
+			wantErr: "email_address is not a valid email",
+		},
+		{
+			name:    "Email with display name",
+			modify:  func(user *models.User) { user.EmailAddress = "Grace <grace@inbox.edu>" },
+			wantErr: "email_address is not a valid email",
+		},
+		{
+			name:    "Created at before epoch",
+			modify:  func(user *models.User) { user.CreatedAt = time.UnixMilli(0) },
+			wantErr: "created_at is out of range",
+		},
+		{
+			name:    "Created at in future",
+			modify:  func(user *models.User) { user.CreatedAt = future },
+			wantErr: "created_at is out of range",
+		},
+		{
+			name:    "Missing created at",
+			modify:  func(user *models.User) { user.CreatedAt = time.Time{} },
+			wantErr: "created_at is required",
+		},
+		{
+			name: "Merged in future",
+			modify: func(user *models.User) {
+				user.MergedAt = &future
+				user.ParentUserID = &parentUserID
+			},
+			wantErr: "merged_at is out of range",
+		},
+		{
+			name:    "Deleted before created",
+			modify:  func(user *models.User) { user.DeletedAt = &before },
+			wantErr: "deleted_at can not be before created_at",
+		},
+		{
+			name:    "Merged without parent",
+			modify:  func(user *models.User) { user.MergedAt = &after },
+			wantErr: "merged_at can only be set with parent_user_id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := valid()
+			tt.modify(&user)
+
+			err := models.ValidateUser(&user)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}